@@ -0,0 +1,489 @@
+// Package client talks to a secretlinks server from Go programs.
+//
+//	c, err := client.New("https://links.example.com", client.WithAPIKey(key))
+//	link, err := c.Create(ctx, client.CreateRequest{Secret: "s3cr3t", TTL: time.Hour, MaxViews: 1})
+//	secret, err := c.Read(ctx, link.URL)
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Errors the server answers with. Use errors.Is; the returned error is an
+// *APIError carrying the server's message.
+var (
+	ErrNotFound  = errors.New("secretlinks: link not found")
+	ErrExpired   = errors.New("secretlinks: link expired")
+	ErrForbidden = errors.New("secretlinks: forbidden")
+	ErrConflict  = errors.New("secretlinks: alias already taken")
+	ErrTooEarly  = errors.New("secretlinks: link not yet available")
+)
+
+// APIError is an unsuccessful response.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("secretlinks: server answered %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrExpired:
+		return e.StatusCode == http.StatusGone
+	case ErrForbidden:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	case ErrTooEarly:
+		return e.StatusCode == http.StatusTooEarly
+	}
+	return false
+}
+
+// Client is safe for concurrent use.
+type Client struct {
+	baseURL    *url.URL
+	apiKey     string
+	httpClient *http.Client
+	retry      RetryPolicy
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithAPIKey sends key as a bearer token with every request to the server,
+// but not when reading a link, which may point at any host.
+func WithAPIKey(key string) Option {
+	return func(c *Client) { c.apiKey = key }
+}
+
+// WithHTTPClient replaces http.DefaultClient.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) { c.httpClient = httpClient }
+}
+
+// WithRetry replaces DefaultRetryPolicy.
+func WithRetry(policy RetryPolicy) Option {
+	return func(c *Client) { c.retry = policy }
+}
+
+// New returns a client for the server at baseURL, including the path
+// prefix if the server has one, e.g. https://example.com/secrets/.
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("secretlinks: %q is not an http or https URL", baseURL)
+	}
+	c := &Client{baseURL: u, httpClient: http.DefaultClient, retry: DefaultRetryPolicy}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// CreateRequest describes a new secret. Zero TTL and MaxViews take the
+// server's defaults. Alias chooses the link's key instead of a random one
+// and needs an API key. AllowedNetworks, CIDR ranges or addresses,
+// restrict who can open the link. A NotBefore time keeps the link closed
+// until then; the TTL counts from it. Webhook is a URL the server POSTs
+// signed events of the link to, see package webhooks; Notify is an email
+// address told when the link is viewed or expires unread and needs an API
+// key.
+type CreateRequest struct {
+	Secret          string
+	TTL             time.Duration
+	MaxViews        int
+	Alias           string
+	AllowedNetworks []string
+	NotBefore       time.Time
+	Webhook         string
+	Notify          string
+}
+
+// Link is a created secret link. RevokeToken is only known to the creator
+// and is needed by Revoke; WebhookSecret verifies the link's webhooks.
+type Link struct {
+	URL           string     `json:"url"`
+	Key           string     `json:"key"`
+	NotBefore     *time.Time `json:"not_before,omitempty"`
+	ExpiresAt     time.Time  `json:"expires_at"`
+	MaxViews      int        `json:"max_views"`
+	RevokeToken   string     `json:"revoke_token"`
+	WebhookSecret string     `json:"webhook_secret,omitempty"`
+}
+
+// Status describes a link without using up a view.
+type Status struct {
+	Key            string     `json:"key"`
+	State          string     `json:"state"`
+	Views          int        `json:"views"`
+	MaxViews       int        `json:"max_views"`
+	RemainingViews int        `json:"remaining_views"`
+	NotBefore      *time.Time `json:"not_before,omitempty"`
+	ExpiresAt      time.Time  `json:"expires_at"`
+}
+
+// Link states. StateRevoked is only reported for members of a group.
+const (
+	StatePending = "pending"
+	StateActive  = "active"
+	StateExpired = "expired"
+	StateRevoked = "revoked"
+)
+
+// Group is the set of links created by CreateGroup. Token is only known
+// to the creator and is needed by GroupStatus; WebhookSecret verifies the
+// webhooks of every link.
+type Group struct {
+	ID            string          `json:"group"`
+	Token         string          `json:"group_token"`
+	NotBefore     *time.Time      `json:"not_before,omitempty"`
+	ExpiresAt     time.Time       `json:"expires_at"`
+	MaxViews      int             `json:"max_views"`
+	Links         []RecipientLink `json:"links"`
+	WebhookSecret string          `json:"webhook_secret,omitempty"`
+}
+
+// RecipientLink is the link of one recipient of a group. RevokeToken
+// revokes just this link.
+type RecipientLink struct {
+	Recipient   string `json:"recipient"`
+	URL         string `json:"url"`
+	Key         string `json:"key"`
+	RevokeToken string `json:"revoke_token"`
+}
+
+// GroupStatus tells who of a group has opened their link.
+type GroupStatus struct {
+	ID        string         `json:"group"`
+	ExpiresAt time.Time      `json:"expires_at"`
+	Members   []MemberStatus `json:"members"`
+}
+
+// MemberStatus describes the link of one recipient. OpenedAt is the time
+// of its first view, nil while unopened.
+type MemberStatus struct {
+	Recipient string     `json:"recipient"`
+	Key       string     `json:"key"`
+	State     string     `json:"state"`
+	Views     int        `json:"views"`
+	MaxViews  int        `json:"max_views"`
+	OpenedAt  *time.Time `json:"opened_at,omitempty"`
+}
+
+// Create stores a secret and returns its link. The TTL is rounded up to
+// whole minutes. A failed request may still have stored the secret, with a
+// link and revoke token the caller never got, so like Read it is only
+// retried on 429 and 503.
+func (c *Client) Create(ctx context.Context, req CreateRequest) (*Link, error) {
+	form, err := createForm(req)
+	if err != nil {
+		return nil, err
+	}
+	var link Link
+	err = c.do(ctx, call{
+		method: http.MethodPost,
+		url:    c.baseURL.JoinPath("create").String(),
+		form:   form,
+	}, &link)
+	if err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// CreateGroup stores a secret once and returns a link for each recipient,
+// in the same order. Every link counts its views and is revoked on its
+// own; the recipients, names or addresses, only tell them apart in
+// GroupStatus. Alias cannot be used. It is retried like Create.
+func (c *Client) CreateGroup(ctx context.Context, req CreateRequest, recipients []string) (*Group, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("secretlinks: no recipients")
+	}
+	if req.Alias != "" {
+		return nil, errors.New("secretlinks: a group cannot have an alias")
+	}
+	form, err := createForm(req)
+	if err != nil {
+		return nil, err
+	}
+	form["recipient"] = recipients
+
+	var group Group
+	err = c.do(ctx, call{
+		method: http.MethodPost,
+		url:    c.baseURL.JoinPath("create").String(),
+		form:   form,
+	}, &group)
+	if err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+// GroupStatus reports who of the group id has opened their link, given
+// the Token from CreateGroup.
+func (c *Client) GroupStatus(ctx context.Context, id, token string) (*GroupStatus, error) {
+	if id == "" || strings.Contains(id, "/") {
+		return nil, fmt.Errorf("secretlinks: %q is not a group id", id)
+	}
+	var status GroupStatus
+	err := c.do(ctx, call{
+		method:      http.MethodGet,
+		url:         c.baseURL.JoinPath("api", "groups", id).String(),
+		header:      http.Header{"X-Group-Token": {token}},
+		retryErrors: true,
+	}, &status)
+	if err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// createForm encodes req as the form of /create.
+func createForm(req CreateRequest) (url.Values, error) {
+	if req.Secret == "" {
+		return nil, errors.New("secretlinks: empty secret")
+	}
+	form := url.Values{"secret": {req.Secret}}
+	if req.TTL > 0 {
+		form.Set("expiration", strconv.Itoa(int((req.TTL+time.Minute-1)/time.Minute)))
+	}
+	if req.MaxViews > 0 {
+		form.Set("maxviews", strconv.Itoa(req.MaxViews))
+	}
+	if req.Alias != "" {
+		form.Set("alias", req.Alias)
+	}
+	for _, network := range req.AllowedNetworks {
+		form.Add("allow", network)
+	}
+	if req.Webhook != "" {
+		form.Set("webhook", req.Webhook)
+	}
+	if req.Notify != "" {
+		form.Set("notify", req.Notify)
+	}
+	if !req.NotBefore.IsZero() {
+		form.Set("not_before", req.NotBefore.UTC().Format(time.RFC3339))
+	}
+	return form, nil
+}
+
+// Read returns the secret and uses up one view. link is a URL returned by
+// Create, including links to the reveal page of the web interface, or a
+// bare key. Since a view may be used up even when the answer is lost,
+// Read only retries when the server says it did not handle the request
+// (429 and 503). Reading needs no API key, so none is sent.
+func (c *Client) Read(ctx context.Context, link string) (string, error) {
+	u, err := c.linkURL(link)
+	if err != nil {
+		return "", err
+	}
+	var secret []byte
+	err = c.do(ctx, call{method: http.MethodGet, url: u, anonymous: true}, &secret)
+	return string(secret), err
+}
+
+// Status reports the state of a link, given as URL or key.
+func (c *Client) Status(ctx context.Context, link string) (*Status, error) {
+	key, err := c.key(link)
+	if err != nil {
+		return nil, err
+	}
+	var status Status
+	err = c.do(ctx, call{
+		method:      http.MethodGet,
+		url:         c.baseURL.JoinPath("api", "links", key).String(),
+		retryErrors: true,
+	}, &status)
+	if err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Revoke deletes a link before it runs out, given its URL or key and the
+// RevokeToken from Create.
+func (c *Client) Revoke(ctx context.Context, link, revokeToken string) error {
+	key, err := c.key(link)
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, call{
+		method:      http.MethodDelete,
+		url:         c.baseURL.JoinPath("api", "links", key).String(),
+		header:      http.Header{"X-Revoke-Token": {revokeToken}},
+		retryErrors: true,
+	}, nil)
+}
+
+// linkURL returns the URL that answers with the raw secret.
+func (c *Client) linkURL(link string) (string, error) {
+	if !strings.Contains(link, "://") {
+		if link == "" || strings.Contains(link, "/") {
+			return "", fmt.Errorf("secretlinks: %q is neither a link nor a key", link)
+		}
+		return c.baseURL.JoinPath(link).String(), nil
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", err
+	}
+	u.Fragment, u.RawFragment = "", ""
+	segments := strings.Split(u.Path, "/")
+	if n := len(segments); n >= 2 && segments[n-2] == "s" {
+		u.Path = strings.Join(append(segments[:n-2], segments[n-1]), "/")
+	}
+	return u.String(), nil
+}
+
+// key returns the key of a link given as URL or key.
+func (c *Client) key(link string) (string, error) {
+	u, err := c.linkURL(link)
+	if err != nil {
+		return "", err
+	}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return "", err
+	}
+	key := parsed.Path[strings.LastIndex(parsed.Path, "/")+1:]
+	if key == "" {
+		return "", fmt.Errorf("secretlinks: %q has no key", link)
+	}
+	return key, nil
+}
+
+type call struct {
+	method string
+	url    string
+	form   url.Values
+	header http.Header
+	// retryErrors also retries network errors and every 5xx, for calls
+	// that are safe to repeat.
+	retryErrors bool
+	// anonymous leaves out the API key, for URLs not built from baseURL.
+	anonymous bool
+}
+
+// do sends the call, retrying as the policy allows, and decodes a
+// successful answer into out: raw bytes for *[]byte, JSON otherwise.
+func (c *Client) do(ctx context.Context, cl call, out any) error {
+	attempt := 0
+	for {
+		attempt++
+		retryAfter, err := c.send(ctx, cl, out)
+		if err == nil || !retryable(err, cl.retryErrors) || attempt >= c.retry.MaxAttempts {
+			return err
+		}
+		delay := c.retry.Backoff(attempt)
+		if retryAfter > delay {
+			delay = retryAfter
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+func retryable(err error, retryErrors bool) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return retryErrors && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	switch {
+	case apiErr.StatusCode == http.StatusTooManyRequests, apiErr.StatusCode == http.StatusServiceUnavailable:
+		return true
+	case apiErr.StatusCode >= 500:
+		return retryErrors
+	}
+	return false
+}
+
+func (c *Client) send(ctx context.Context, cl call, out any) (time.Duration, error) {
+	var body io.Reader
+	if cl.form != nil {
+		body = strings.NewReader(cl.form.Encode())
+	}
+	req, err := http.NewRequestWithContext(ctx, cl.method, cl.url, body)
+	if err != nil {
+		return 0, err
+	}
+	for name, values := range cl.header {
+		req.Header[name] = values
+	}
+	if cl.form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if _, raw := out.(*[]byte); !raw {
+		req.Header.Set("Accept", "application/json")
+	}
+	if c.apiKey != "" && !cl.anonymous {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	if resp.StatusCode >= 300 {
+		return parseRetryAfter(resp.Header.Get("Retry-After")), &APIError{StatusCode: resp.StatusCode, Message: errorMessage(data)}
+	}
+	switch out := out.(type) {
+	case nil:
+	case *[]byte:
+		*out = data
+	default:
+		if err := json.Unmarshal(data, out); err != nil {
+			return 0, fmt.Errorf("secretlinks: decode response: %w", err)
+		}
+	}
+	return 0, nil
+}
+
+// errorMessage takes the message from a JSON error body or plain text.
+func errorMessage(body []byte) string {
+	var e struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &e) == nil && e.Error != "" {
+		return e.Error
+	}
+	return strings.TrimSpace(string(body))
+}
+
+func parseRetryAfter(value string) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return time.Until(at)
+	}
+	return 0
+}
@@ -0,0 +1,344 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"secretlinks/handlers"
+	"secretlinks/middleware"
+	"secretlinks/storage"
+	"secretlinks/webhooks"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var fastRetry = RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+
+// newServer runs the real handlers, routed as in main, behind wrap.
+func newServer(t *testing.T, wrap func(http.Handler) http.Handler) (*httptest.Server, *storage.MemoryStorage) {
+	memoryStorage := storage.NewMemoryStorage()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/create", handlers.CreateHandler(memoryStorage))
+	mux.HandleFunc("/", handlers.RedirectHandler(memoryStorage))
+	mux.HandleFunc("GET /api/links/{key}", handlers.StatusHandler(memoryStorage))
+	mux.HandleFunc("DELETE /api/links/{key}", handlers.RevokeHandler(memoryStorage))
+	mux.HandleFunc("GET /api/groups/{id}", handlers.GroupStatusHandler(memoryStorage))
+	mux.Handle("/s/{key}", handlers.UIRevealHandler(memoryStorage))
+	var handler http.Handler = mux
+	if wrap != nil {
+		handler = wrap(mux)
+	}
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server, memoryStorage
+}
+
+func newClient(t *testing.T, server *httptest.Server, opts ...Option) *Client {
+	c, err := New(server.URL, append([]Option{WithRetry(fastRetry)}, opts...)...)
+	require.NoError(t, err)
+	return c
+}
+
+func TestCreateReadStatus(t *testing.T) {
+	server, _ := newServer(t, nil)
+	c := newClient(t, server)
+	ctx := context.Background()
+
+	link, err := c.Create(ctx, CreateRequest{Secret: "s3cr3t", TTL: 90 * time.Second, MaxViews: 2})
+	require.NoError(t, err)
+	assert.Equal(t, server.URL+"/"+link.Key, link.URL)
+	assert.Equal(t, 2, link.MaxViews)
+	assert.NotEmpty(t, link.RevokeToken)
+	assert.WithinDuration(t, time.Now().Add(2*time.Minute), link.ExpiresAt, 5*time.Second)
+
+	status, err := c.Status(ctx, link.URL)
+	require.NoError(t, err)
+	assert.Equal(t, Status{Key: link.Key, State: StateActive, MaxViews: 2, RemainingViews: 2, ExpiresAt: status.ExpiresAt}, *status)
+
+	secret, err := c.Read(ctx, link.URL)
+	require.NoError(t, err)
+	assert.Equal(t, "s3cr3t", secret)
+
+	secret, err = c.Read(ctx, link.Key)
+	require.NoError(t, err)
+	assert.Equal(t, "s3cr3t", secret)
+
+	status, err = c.Status(ctx, link.Key)
+	require.NoError(t, err)
+	assert.Equal(t, StateExpired, status.State)
+	assert.Equal(t, 0, status.RemainingViews)
+
+	_, err = c.Read(ctx, link.URL)
+	assert.ErrorIs(t, err, ErrExpired)
+	_, err = c.Read(ctx, link.URL)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = c.Status(ctx, link.URL)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestCreateNotBefore(t *testing.T) {
+	server, memoryStorage := newServer(t, nil)
+	c := newClient(t, server)
+	ctx := context.Background()
+
+	monday := time.Now().Add(48 * time.Hour).Truncate(time.Second)
+	link, err := c.Create(ctx, CreateRequest{Secret: "welcome", TTL: time.Hour, NotBefore: monday})
+	require.NoError(t, err)
+	require.NotNil(t, link.NotBefore)
+	assert.True(t, monday.Equal(*link.NotBefore))
+	assert.True(t, monday.Add(time.Hour).Equal(link.ExpiresAt))
+
+	_, err = c.Read(ctx, link.URL)
+	assert.ErrorIs(t, err, ErrTooEarly)
+	status, err := c.Status(ctx, link.Key)
+	require.NoError(t, err)
+	assert.Equal(t, StatePending, status.State)
+	assert.Equal(t, 1, status.RemainingViews)
+
+	stored, _ := memoryStorage.Get(link.Key)
+	stored.NotBefore = time.Now().Add(-time.Second)
+	memoryStorage.Update(link.Key, stored)
+	secret, err := c.Read(ctx, link.URL)
+	require.NoError(t, err)
+	assert.Equal(t, "welcome", secret)
+}
+
+func TestCreateWithWebhook(t *testing.T) {
+	handlers.Webhooks = webhooks.NewDispatcher(webhooks.Options{})
+	defer func() {
+		handlers.Webhooks.Close(context.Background())
+		handlers.Webhooks = nil
+	}()
+	server, memoryStorage := newServer(t, nil)
+	c := newClient(t, server)
+
+	link, err := c.Create(context.Background(), CreateRequest{Secret: "x", Webhook: "https://hooks.example.com/secretlinks"})
+	require.NoError(t, err)
+	assert.NotEmpty(t, link.WebhookSecret)
+	stored, _ := memoryStorage.Get(link.Key)
+	assert.Equal(t, "https://hooks.example.com/secretlinks", stored.WebhookURL)
+	assert.Equal(t, link.WebhookSecret, stored.WebhookSecret)
+}
+
+func TestReadRevealPageLink(t *testing.T) {
+	server, _ := newServer(t, nil)
+	c := newClient(t, server)
+
+	link, err := c.Create(context.Background(), CreateRequest{Secret: "via ui"})
+	require.NoError(t, err)
+	secret, err := c.Read(context.Background(), server.URL+"/s/"+link.Key+"#ignored")
+	require.NoError(t, err)
+	assert.Equal(t, "via ui", secret)
+}
+
+func TestReadSendsNoAPIKey(t *testing.T) {
+	server, _ := newServer(t, nil)
+	var authorization []string
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = append(authorization, r.Header.Get("Authorization"))
+		w.Write([]byte("not yours"))
+	}))
+	defer other.Close()
+	c := newClient(t, server, WithAPIKey("sk-test"))
+
+	_, err := c.Read(context.Background(), other.URL+"/AbCdEfGh")
+	require.NoError(t, err)
+	assert.Equal(t, []string{""}, authorization, "the API key must not reach another host")
+}
+
+func TestRevoke(t *testing.T) {
+	server, memoryStorage := newServer(t, nil)
+	c := newClient(t, server)
+	ctx := context.Background()
+
+	link, err := c.Create(ctx, CreateRequest{Secret: "oops, wrong chat"})
+	require.NoError(t, err)
+
+	err = c.Revoke(ctx, link.URL, "not-the-token")
+	assert.ErrorIs(t, err, ErrForbidden)
+	assert.Equal(t, 1, memoryStorage.Len())
+
+	require.NoError(t, c.Revoke(ctx, link.URL, link.RevokeToken))
+	assert.Equal(t, 0, memoryStorage.Len())
+	_, err = c.Read(ctx, link.URL)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, c.Revoke(ctx, link.Key, link.RevokeToken), ErrNotFound)
+}
+
+func TestCreateGroup(t *testing.T) {
+	server, _ := newServer(t, nil)
+	c := newClient(t, server)
+	ctx := context.Background()
+
+	group, err := c.CreateGroup(ctx, CreateRequest{Secret: "db password", MaxViews: 2}, []string{"alice", "bob", "carol"})
+	require.NoError(t, err)
+	require.Len(t, group.Links, 3)
+	assert.Equal(t, "bob", group.Links[1].Recipient)
+	assert.Equal(t, 2, group.MaxViews)
+
+	secret, err := c.Read(ctx, group.Links[0].URL)
+	require.NoError(t, err)
+	assert.Equal(t, "db password", secret)
+	require.NoError(t, c.Revoke(ctx, group.Links[1].URL, group.Links[1].RevokeToken))
+
+	_, err = c.GroupStatus(ctx, group.ID, "not-the-token")
+	assert.ErrorIs(t, err, ErrForbidden)
+	status, err := c.GroupStatus(ctx, group.ID, group.Token)
+	require.NoError(t, err)
+	require.Len(t, status.Members, 3)
+	assert.Equal(t, 1, status.Members[0].Views)
+	assert.NotNil(t, status.Members[0].OpenedAt)
+	assert.Equal(t, StateRevoked, status.Members[1].State)
+	assert.Equal(t, StateActive, status.Members[2].State)
+	assert.Nil(t, status.Members[2].OpenedAt)
+
+	_, err = c.CreateGroup(ctx, CreateRequest{Secret: "x", Alias: "team"}, []string{"alice"})
+	assert.Error(t, err)
+	_, err = c.CreateGroup(ctx, CreateRequest{Secret: "x"}, nil)
+	assert.Error(t, err)
+}
+
+// failFirst answers the first n requests with status and passes the rest.
+func failFirst(n int32, status int, calls *atomic.Int32) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) <= n {
+				w.Header().Set("Retry-After", "0")
+				http.Error(w, "try again", status)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestRetriesOverloadedServer(t *testing.T) {
+	var calls atomic.Int32
+	server, _ := newServer(t, failFirst(2, http.StatusTooManyRequests, &calls))
+	c := newClient(t, server)
+
+	link, err := c.Create(context.Background(), CreateRequest{Secret: "eventually"})
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), calls.Load())
+	assert.NotEmpty(t, link.Key)
+}
+
+func TestGivesUpAfterMaxAttempts(t *testing.T) {
+	var calls atomic.Int32
+	server, _ := newServer(t, failFirst(10, http.StatusBadGateway, &calls))
+	c := newClient(t, server)
+
+	_, err := c.Status(context.Background(), "AbCdEfGh")
+	var apiErr *APIError
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusBadGateway, apiErr.StatusCode)
+	assert.Equal(t, "try again", apiErr.Message)
+	assert.Equal(t, int32(fastRetry.MaxAttempts), calls.Load())
+}
+
+func TestReadDoesNotRetryAmbiguousFailures(t *testing.T) {
+	var calls atomic.Int32
+	server, _ := newServer(t, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet {
+				calls.Add(1)
+				http.Error(w, "boom", http.StatusInternalServerError)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	c := newClient(t, server)
+
+	_, err := c.Read(context.Background(), "AbCdEfGh")
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), calls.Load(), "a view may have been used up")
+}
+
+func TestCreateDoesNotRetryAmbiguousFailures(t *testing.T) {
+	var calls atomic.Int32
+	server, memoryStorage := newServer(t, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			// Stored, but the answer is lost on the way.
+			next.ServeHTTP(httptest.NewRecorder(), r)
+			http.Error(w, "bad gateway", http.StatusBadGateway)
+		})
+	})
+	c := newClient(t, server)
+
+	_, err := c.Create(context.Background(), CreateRequest{Secret: "once"})
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), calls.Load(), "a retry would store a copy nobody can revoke")
+	assert.Equal(t, 1, memoryStorage.Len())
+
+	_, err = c.CreateGroup(context.Background(), CreateRequest{Secret: "once"}, []string{"alice"})
+	assert.Error(t, err)
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestContextCancelsRetries(t *testing.T) {
+	var calls atomic.Int32
+	server, _ := newServer(t, failFirst(100, http.StatusServiceUnavailable, &calls))
+	c := newClient(t, server, WithRetry(RetryPolicy{MaxAttempts: 100, InitialBackoff: time.Hour, MaxBackoff: time.Hour}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := c.Create(ctx, CreateRequest{Secret: "cancelled"})
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestSendsAPIKey(t *testing.T) {
+	var auth string
+	server, _ := newServer(t, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			auth = r.Header.Get("Authorization")
+			next.ServeHTTP(w, r)
+		})
+	})
+	c := newClient(t, server, WithAPIKey("sk-test"))
+
+	_, err := c.Create(context.Background(), CreateRequest{Secret: "x"})
+	require.NoError(t, err)
+	assert.Equal(t, "Bearer sk-test", auth)
+}
+
+func TestCreateWithAlias(t *testing.T) {
+	server, _ := newServer(t, func(next http.Handler) http.Handler {
+		return middleware.AuthMiddleware(middleware.NewAPIKeys([]string{"sk-test"}), next)
+	})
+	c := newClient(t, server, WithAPIKey("sk-test"))
+	ctx := context.Background()
+
+	link, err := c.Create(ctx, CreateRequest{Secret: "welcome", Alias: "onboarding"})
+	require.NoError(t, err)
+	assert.Equal(t, "onboarding", link.Key)
+	assert.Equal(t, server.URL+"/onboarding", link.URL)
+
+	_, err = c.Create(ctx, CreateRequest{Secret: "again", Alias: "onboarding"})
+	assert.ErrorIs(t, err, ErrConflict)
+
+	_, err = newClient(t, server).Create(ctx, CreateRequest{Secret: "anonymous", Alias: "mine"})
+	assert.ErrorIs(t, err, ErrForbidden)
+}
+
+func TestNewRejectsBadURL(t *testing.T) {
+	for _, raw := range []string{"", "localhost:8080", "ftp://example.com", "/secrets"} {
+		_, err := New(raw)
+		assert.Error(t, err, raw)
+	}
+	c, err := New("https://example.com/secrets/")
+	require.NoError(t, err)
+	u, err := c.linkURL("AbCdEfGh")
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(u, "/secrets/AbCdEfGh"), u)
+}
@@ -0,0 +1,33 @@
+package client
+
+import "time"
+
+// RetryPolicy describes how many times a request is attempted and how
+// long to wait between attempts. The delay doubles after every failure;
+// a longer Retry-After from the server wins.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    4,
+	InitialBackoff: 200 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+}
+
+// NoRetry makes every request once.
+var NoRetry = RetryPolicy{MaxAttempts: 1}
+
+// Backoff returns the delay after the given failed attempt (starting at 1).
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	delay := p.InitialBackoff
+	for i := 1; i < attempt && delay < p.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	return delay
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultServer = "http://localhost:8080"
+
+	configEnv     = "SECRETLINKS_CLIENT_CONFIG"
+	serverEnv     = "SECRETLINKS_SERVER"
+	apiKeyEnv     = "SECRETLINKS_API_KEY"
+	passphraseEnv = "SECRETLINKS_PASSPHRASE"
+)
+
+// clientConfig is saved by "secretlinks config".
+type clientConfig struct {
+	Server string `yaml:"server,omitempty"`
+	APIKey string `yaml:"api_key,omitempty"`
+}
+
+func defaultConfigPath() string {
+	if path := os.Getenv(configEnv); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "secretlinks.yaml"
+	}
+	return filepath.Join(dir, "secretlinks", "client.yaml")
+}
+
+// loadClientConfig reads path; a missing file is an empty configuration.
+func loadClientConfig(path string) (clientConfig, error) {
+	var cfg clientConfig
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// save writes the configuration readable by the owner only, since it
+// holds the API key.
+func (c clientConfig) save(path string) error {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return err
+	}
+	return os.Chmod(path, 0o600)
+}
+
+func maskKey(key string) string {
+	if len(key) <= 4 {
+		return "****"
+	}
+	return "****" + key[len(key)-4:]
+}
+
+func runConfig(args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("config", stderr)
+	path := flags.String("config", defaultConfigPath(), "client configuration file")
+	server := flags.String("server", "", "save this server URL")
+	apiKey := flags.String("api-key", "", "save this API key")
+	output := flags.String("o", "plain", "output format: plain or json")
+	rest, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return usageError("config takes no arguments")
+	}
+
+	cfg, err := loadClientConfig(*path)
+	if err != nil {
+		return err
+	}
+	if *server != "" || *apiKey != "" {
+		if *server != "" {
+			if _, err := parseServer(*server); err != nil {
+				return err
+			}
+			cfg.Server = *server
+		}
+		if *apiKey != "" {
+			cfg.APIKey = *apiKey
+		}
+		if err := cfg.save(*path); err != nil {
+			return err
+		}
+	}
+
+	shown := clientConfig{Server: cfg.Server}
+	if cfg.APIKey != "" {
+		shown.APIKey = maskKey(cfg.APIKey)
+	}
+	if *output == "json" {
+		return printJSON(stdout, struct {
+			Path   string `json:"path"`
+			Server string `json:"server,omitempty"`
+			APIKey string `json:"api_key,omitempty"`
+		}{*path, shown.Server, shown.APIKey})
+	}
+	fmt.Fprintf(stdout, "config:  %s\nserver:  %s\napi key: %s\n", *path, orNone(shown.Server), orNone(shown.APIKey))
+	return nil
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "(not set)"
+	}
+	return s
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"secretlinks/client"
+	"secretlinks/qr"
+	"strings"
+	"time"
+)
+
+func runCreate(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	flags := newFlagSet("create", stderr)
+	var common commonFlags
+	common.register(flags)
+	var pass passphraseFlags
+	pass.register(flags)
+	ttl := flags.Duration("ttl", 0, "how long the link works, e.g. 30m or 2h (default: the server's)")
+	views := flags.Int("views", 0, "how many times the link can be opened (default: the server's)")
+	encrypt := flags.Bool("encrypt", false, "encrypt on this machine with a random key carried in the link after '#'")
+	file := flags.String("file", "", "read the secret from this file instead of stdin")
+	alias := flags.String("alias", "", "use this key instead of a random one, e.g. team-wifi (needs an API key)")
+	allow := flags.String("allow", "", "comma-separated CIDR ranges or addresses the link can be opened from, e.g. 10.0.0.0/8")
+	notBefore := flags.String("not-before", "", "keep the link closed until this RFC 3339 time, e.g. 2025-07-21T09:00:00+02:00; -ttl counts from it")
+	webhook := flags.String("webhook", "", "URL the server POSTs signed events of the link to: viewed, exhausted, expired, revoked")
+	notifyEmail := flags.String("notify", "", "email address told when the link is viewed or expires unread (needs an API key)")
+	to := flags.String("to", "", "comma-separated recipients, e.g. alice,bob: one link each, followed with \"secretlinks group\"")
+	qrOut := flags.String("qr", "", "also write the link as QR code: a .png or .svg file, or - to draw it in the terminal")
+	rest, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	switch {
+	case len(rest) > 1:
+		return usageError("create takes at most one file")
+	case len(rest) == 1 && *file != "":
+		return usageError("give the file either as argument or with -file")
+	case len(rest) == 1:
+		*file = rest[0]
+	}
+	if *ttl < 0 || *views < 0 {
+		return usageError("-ttl and -views must not be negative")
+	}
+	var activation time.Time
+	if *notBefore != "" {
+		if activation, err = time.Parse(time.RFC3339, *notBefore); err != nil {
+			return usageError(fmt.Sprintf("-not-before %q is not an RFC 3339 time", *notBefore))
+		}
+	}
+	var recipients []string
+	for _, recipient := range strings.Split(*to, ",") {
+		if recipient = strings.TrimSpace(recipient); recipient != "" {
+			recipients = append(recipients, recipient)
+		}
+	}
+	if len(recipients) > 0 && (*qrOut != "" || *alias != "") {
+		return usageError("-to cannot be combined with -qr or -alias")
+	}
+	if *qrOut == "-" && common.output == "json" {
+		return usageError("-qr - cannot be combined with -o json; write the QR code to a file")
+	}
+
+	cfg, err := common.settings()
+	if err != nil {
+		return err
+	}
+	passphrase, err := pass.value()
+	if err != nil {
+		return err
+	}
+	if *encrypt && passphrase != "" {
+		return usageError("use either -encrypt or a passphrase")
+	}
+
+	secret, err := readSecret(*file, stdin)
+	if err != nil {
+		return err
+	}
+
+	var fragment string
+	text := string(secret)
+	switch {
+	case *encrypt:
+		text, fragment, err = encryptWithKey(secret)
+	case passphrase != "":
+		text, err = encryptWithPassphrase(secret, passphrase)
+	}
+	if err != nil {
+		return err
+	}
+
+	c, err := newClient(cfg)
+	if err != nil {
+		return err
+	}
+	req := client.CreateRequest{Secret: text, TTL: *ttl, MaxViews: *views, Alias: *alias, NotBefore: activation, Webhook: *webhook, Notify: *notifyEmail}
+	if *allow != "" {
+		// The server splits the list itself.
+		req.AllowedNetworks = []string{*allow}
+	}
+	if len(recipients) > 0 {
+		return createGroup(c, req, recipients, fragment, passphrase != "", common.output, stdout, stderr)
+	}
+	link, err := c.Create(context.Background(), req)
+	if err != nil {
+		return explain(err)
+	}
+	if fragment != "" {
+		link.URL += "#" + fragment
+	}
+
+	// The QR code is drawn here rather than by the server, so it can hold
+	// the key after '#' of an encrypted link.
+	var terminalQR string
+	switch {
+	case *qrOut == "-":
+		if terminalQR, err = qr.Terminal(link.URL); err != nil {
+			return err
+		}
+	case *qrOut != "":
+		format := qr.PNG
+		if strings.EqualFold(filepath.Ext(*qrOut), ".svg") {
+			format = qr.SVG
+		}
+		image, err := qr.Encode(link.URL, format)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(*qrOut, image, 0o600); err != nil {
+			return err
+		}
+	}
+
+	if common.output == "json" {
+		return printJSON(stdout, struct {
+			URL           string     `json:"url"`
+			NotBefore     *time.Time `json:"not_before,omitempty"`
+			ExpiresAt     time.Time  `json:"expires_at"`
+			Views         int        `json:"views"`
+			Encrypted     bool       `json:"encrypted"`
+			RevokeToken   string     `json:"revoke_token"`
+			WebhookSecret string     `json:"webhook_secret,omitempty"`
+		}{link.URL, link.NotBefore, link.ExpiresAt, link.MaxViews, fragment != "" || passphrase != "", link.RevokeToken, link.WebhookSecret})
+	}
+	fmt.Fprintln(stdout, link.URL)
+	if link.WebhookSecret != "" {
+		fmt.Fprintln(stderr, "webhook secret:", link.WebhookSecret)
+	}
+	fmt.Fprint(stdout, terminalQR)
+	return nil
+}
+
+// createGroup creates a link for each recipient and prints them with the
+// group's id and token, which "secretlinks group" needs.
+func createGroup(c *client.Client, req client.CreateRequest, recipients []string, fragment string, passphrase bool, output string, stdout, stderr io.Writer) error {
+	group, err := c.CreateGroup(context.Background(), req, recipients)
+	if err != nil {
+		return explain(err)
+	}
+	if fragment != "" {
+		for i := range group.Links {
+			group.Links[i].URL += "#" + fragment
+		}
+	}
+
+	if output == "json" {
+		return printJSON(stdout, struct {
+			*client.Group
+			Encrypted bool `json:"encrypted"`
+		}{group, fragment != "" || passphrase})
+	}
+	for _, link := range group.Links {
+		fmt.Fprintf(stdout, "%s\t%s\n", link.Recipient, link.URL)
+	}
+	if group.WebhookSecret != "" {
+		fmt.Fprintln(stderr, "webhook secret:", group.WebhookSecret)
+	}
+	fmt.Fprintf(stderr, "see who opened their link: secretlinks group %s -token %s\n", group.ID, group.Token)
+	return nil
+}
+
+func readSecret(file string, stdin io.Reader) ([]byte, error) {
+	var secret []byte
+	var err error
+	if file != "" && file != "-" {
+		secret, err = os.ReadFile(file)
+	} else {
+		secret, err = io.ReadAll(stdin)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(secret) == 0 {
+		return nil, usageError("the secret is empty")
+	}
+	return secret, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Secrets encrypted by the client are sent to the server as text with one
+// of these prefixes, so "get" knows how to open them. With a random key
+// the key travels in the fragment of the link, which browsers and HTTP
+// clients never send to the server; with a passphrase it is derived from
+// the passphrase and a salt stored next to the ciphertext.
+const (
+	keyPrefix        = "slenc:v1:"
+	passphrasePrefix = "slenc:v1p:"
+
+	keySize  = 32
+	saltSize = 16
+)
+
+var (
+	errNeedKey        = errors.New("the secret is encrypted; the link must include the key after '#'")
+	errNeedPassphrase = errors.New("the secret is protected by a passphrase; pass -passphrase-file, -passphrase or $" + passphraseEnv)
+	errDecrypt        = errors.New("cannot decrypt the secret: wrong key or passphrase, or the data was altered")
+)
+
+var encoding = base64.RawURLEncoding
+
+// encryptWithKey encrypts plaintext with a new random key and returns the
+// text for the server and the key for the link fragment.
+func encryptWithKey(plaintext []byte) (string, string, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", "", err
+	}
+	sealed, err := seal(key, plaintext)
+	if err != nil {
+		return "", "", err
+	}
+	return keyPrefix + encoding.EncodeToString(sealed), encoding.EncodeToString(key), nil
+}
+
+func encryptWithPassphrase(plaintext []byte, passphrase string) (string, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := deriveKey(passphrase, salt)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := seal(key, plaintext)
+	if err != nil {
+		return "", err
+	}
+	return passphrasePrefix + encoding.EncodeToString(append(salt, sealed...)), nil
+}
+
+// decrypt opens a secret fetched from the server. Secrets the client did
+// not encrypt are returned unchanged.
+func decrypt(secret []byte, fragment, passphrase string) ([]byte, error) {
+	text := string(secret)
+	switch {
+	case strings.HasPrefix(text, keyPrefix):
+		if fragment == "" {
+			return nil, errNeedKey
+		}
+		key, err := encoding.DecodeString(fragment)
+		if err != nil || len(key) != keySize {
+			return nil, errDecrypt
+		}
+		sealed, err := encoding.DecodeString(strings.TrimPrefix(text, keyPrefix))
+		if err != nil {
+			return nil, errDecrypt
+		}
+		return open(key, sealed)
+
+	case strings.HasPrefix(text, passphrasePrefix):
+		if passphrase == "" {
+			return nil, errNeedPassphrase
+		}
+		data, err := encoding.DecodeString(strings.TrimPrefix(text, passphrasePrefix))
+		if err != nil || len(data) < saltSize {
+			return nil, errDecrypt
+		}
+		key, err := deriveKey(passphrase, data[:saltSize])
+		if err != nil {
+			return nil, err
+		}
+		return open(key, data[saltSize:])
+	}
+	return secret, nil
+}
+
+func deriveKey(passphrase string, salt []byte) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, keySize)
+}
+
+// seal returns nonce || AES-256-GCM ciphertext.
+func seal(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(key, sealed []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errDecrypt
+	}
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, errDecrypt
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"secretlinks/client"
+	"time"
+)
+
+func runGet(args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("get", stderr)
+	var common commonFlags
+	common.register(flags)
+	var pass passphraseFlags
+	pass.register(flags)
+	rest, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 {
+		return usageError("get takes exactly one link")
+	}
+
+	cfg, err := common.settings()
+	if err != nil {
+		return err
+	}
+	passphrase, err := pass.value()
+	if err != nil {
+		return err
+	}
+	fragment, err := linkFragment(rest[0])
+	if err != nil {
+		return err
+	}
+
+	c, err := newClient(cfg)
+	if err != nil {
+		return err
+	}
+	text, err := c.Read(context.Background(), rest[0])
+	if err != nil {
+		return explain(err)
+	}
+	secret, err := decrypt([]byte(text), fragment, passphrase)
+	if err != nil {
+		return err
+	}
+
+	if common.output == "json" {
+		return printJSON(stdout, struct {
+			Secret string `json:"secret"`
+		}{string(secret)})
+	}
+	_, err = stdout.Write(secret)
+	return err
+}
+
+// linkFragment checks that link is a URL and returns the key after '#'
+// that "create -encrypt" appends.
+func linkFragment(link string) (string, error) {
+	u, err := url.Parse(link)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", usageError(fmt.Sprintf("%q is not a secret link", link))
+	}
+	return u.Fragment, nil
+}
+
+func newClient(cfg clientConfig) (*client.Client, error) {
+	if _, err := parseServer(cfg.Server); err != nil {
+		return nil, err
+	}
+	return client.New(cfg.Server,
+		client.WithAPIKey(cfg.APIKey),
+		client.WithHTTPClient(&http.Client{Timeout: 30 * time.Second}),
+	)
+}
+
+func parseServer(server string) (*url.URL, error) {
+	u, err := url.Parse(server)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, usageError(fmt.Sprintf("server %q must be an http or https URL", server))
+	}
+	return u, nil
+}
+
+// explain rewords the errors a user is likely to meet.
+func explain(err error) error {
+	switch {
+	case errors.Is(err, client.ErrNotFound):
+		return errors.New("the secret does not exist or was already viewed")
+	case errors.Is(err, client.ErrExpired):
+		return errors.New("the link has expired")
+	case errors.Is(err, client.ErrForbidden):
+		// The server says why: a wrong API key or a link restricted to other networks.
+		return fmt.Errorf("access denied: %w", err)
+	case errors.Is(err, client.ErrTooEarly):
+		return errors.New("the secret is not available yet, try again later")
+	case errors.Is(err, client.ErrConflict):
+		return errors.New("the alias is already taken, choose another one")
+	}
+	return err
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"secretlinks/client"
+	"text/tabwriter"
+	"time"
+)
+
+func runGroup(args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("group", stderr)
+	var common commonFlags
+	common.register(flags)
+	token := flags.String("token", "", "group token printed by \"secretlinks create -to\"")
+	rest, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 {
+		return usageError("group takes exactly one group id")
+	}
+	if *token == "" {
+		return usageError("-token is required")
+	}
+
+	cfg, err := common.settings()
+	if err != nil {
+		return err
+	}
+	c, err := newClient(cfg)
+	if err != nil {
+		return err
+	}
+	status, err := c.GroupStatus(context.Background(), rest[0], *token)
+	if errors.Is(err, client.ErrNotFound) {
+		return errors.New("the group does not exist or has expired")
+	}
+	if err != nil {
+		return explain(err)
+	}
+
+	if common.output == "json" {
+		return printJSON(stdout, status)
+	}
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "RECIPIENT\tSTATE\tVIEWS\tOPENED")
+	for _, member := range status.Members {
+		opened := "-"
+		if member.OpenedAt != nil {
+			opened = member.OpenedAt.Local().Format(time.DateTime)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d/%d\t%s\n", member.Recipient, member.State, member.Views, member.MaxViews, opened)
+	}
+	return tw.Flush()
+}
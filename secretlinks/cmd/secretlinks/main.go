@@ -0,0 +1,181 @@
+// Command secretlinks is the command-line client of the secretlinks server.
+//
+//	secretlinks create --ttl 2h --views 3 < creds.txt
+//	secretlinks get https://links.example.com/AbCdEfGh
+//	secretlinks create --to alice,bob,carol < db-password.txt
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const usage = `usage: secretlinks <command> [flags] [args]
+
+commands:
+  create [file]   store a secret read from file or stdin and print its link
+  get <link>      print the secret behind a link, using up one view
+  group <id>      show who of a group created with "create -to" has opened their link
+  config          show the saved server URL and API key, or change them
+
+Run "secretlinks <command> -h" for the flags of a command.
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+
+	var err error
+	switch args[0] {
+	case "create":
+		err = runCreate(args[1:], stdin, stdout, stderr)
+	case "get":
+		err = runGet(args[1:], stdout, stderr)
+	case "group":
+		err = runGroup(args[1:], stdout, stderr)
+	case "config":
+		err = runConfig(args[1:], stdout, stderr)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return 0
+	default:
+		fmt.Fprintf(stderr, "unknown command %q\n\n%s", args[0], usage)
+		return 2
+	}
+
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.As(err, new(usageError)):
+		fmt.Fprintln(stderr, "secretlinks:", err)
+		return 2
+	default:
+		fmt.Fprintln(stderr, "secretlinks:", err)
+		return 1
+	}
+}
+
+// usageError is a mistake in the command line rather than a failure.
+type usageError string
+
+func (e usageError) Error() string { return string(e) }
+
+// commonFlags are accepted by every command that talks to the server.
+type commonFlags struct {
+	configPath string
+	server     string
+	apiKey     string
+	output     string
+}
+
+func (c *commonFlags) register(flags *flag.FlagSet) {
+	flags.StringVar(&c.configPath, "config", defaultConfigPath(), "client configuration file")
+	flags.StringVar(&c.server, "server", "", "server URL (default from the configuration file or $"+serverEnv+")")
+	flags.StringVar(&c.apiKey, "api-key", "", "API key (default from the configuration file or $"+apiKeyEnv+")")
+	flags.StringVar(&c.output, "o", "plain", "output format: plain or json")
+}
+
+// settings merges the flags over the environment over the configuration
+// file.
+func (c *commonFlags) settings() (clientConfig, error) {
+	if c.output != "plain" && c.output != "json" {
+		return clientConfig{}, usageError(fmt.Sprintf("unknown output format %q", c.output))
+	}
+	cfg, err := loadClientConfig(c.configPath)
+	if err != nil {
+		return cfg, err
+	}
+	if v := os.Getenv(serverEnv); v != "" {
+		cfg.Server = v
+	}
+	if v := os.Getenv(apiKeyEnv); v != "" {
+		cfg.APIKey = v
+	}
+	if c.server != "" {
+		cfg.Server = c.server
+	}
+	if c.apiKey != "" {
+		cfg.APIKey = c.apiKey
+	}
+	if cfg.Server == "" {
+		cfg.Server = defaultServer
+	}
+	return cfg, nil
+}
+
+// parseFlags parses flags that may come before or after the positional
+// arguments, as in "get <link> -o json".
+func parseFlags(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := flags.Args()
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		args = rest
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func printJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func newFlagSet(name string, stderr io.Writer) *flag.FlagSet {
+	flags := flag.NewFlagSet("secretlinks "+name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	return flags
+}
+
+// passphraseFlags select a passphrase for client-side encryption.
+type passphraseFlags struct {
+	passphrase string
+	file       string
+}
+
+func (p *passphraseFlags) register(flags *flag.FlagSet) {
+	flags.StringVar(&p.passphrase, "passphrase", "", "passphrase for client-side encryption; visible to other local users, prefer -passphrase-file or $"+passphraseEnv)
+	flags.StringVar(&p.file, "passphrase-file", "", "read the passphrase from the first line of this file")
+}
+
+// value returns the passphrase, or "" when none was given.
+func (p *passphraseFlags) value() (string, error) {
+	if p.passphrase != "" {
+		return p.passphrase, nil
+	}
+	if p.file != "" {
+		data, err := os.ReadFile(p.file)
+		if err != nil {
+			return "", err
+		}
+		line, _, _ := strings.Cut(string(data), "\n")
+		line = strings.TrimSuffix(line, "\r")
+		if line == "" {
+			return "", fmt.Errorf("%s: empty passphrase", p.file)
+		}
+		return line, nil
+	}
+	return os.Getenv(passphraseEnv), nil
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"secretlinks/handlers"
+	"secretlinks/storage"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testServer runs the real handlers and records the form of every create
+// request, to check what the server gets to see.
+type testServer struct {
+	*httptest.Server
+	forms []map[string]string
+}
+
+func newTestServer(t *testing.T) *testServer {
+	ts := &testServer{}
+	memoryStorage := storage.NewMemoryStorage()
+	create := handlers.CreateHandler(memoryStorage)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/create", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form := map[string]string{}
+		for k := range r.PostForm {
+			form[k] = r.PostForm.Get(k)
+		}
+		form["authorization"] = r.Header.Get("Authorization")
+		ts.forms = append(ts.forms, form)
+		create(w, r)
+	})
+	mux.HandleFunc("/", handlers.RedirectHandler(memoryStorage))
+	mux.Handle("/s/{key}", handlers.UIRevealHandler(memoryStorage))
+	mux.HandleFunc("GET /api/groups/{id}", handlers.GroupStatusHandler(memoryStorage))
+	ts.Server = httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	t.Setenv(configEnv, filepath.Join(t.TempDir(), "client.yaml"))
+	t.Setenv(serverEnv, ts.URL)
+	t.Setenv(apiKeyEnv, "")
+	t.Setenv(passphraseEnv, "")
+	return ts
+}
+
+func runCLI(t *testing.T, stdin string, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestCreateAndGet(t *testing.T) {
+	ts := newTestServer(t)
+
+	code, out, stderr := runCLI(t, "db password\n", "create", "--ttl", "90s", "--views", "2")
+	assert.Equal(t, 0, code, stderr)
+	link := strings.TrimSpace(out)
+	assert.True(t, strings.HasPrefix(link, ts.URL+"/"), link)
+	assert.Equal(t, "2", ts.forms[0]["expiration"], "ttl is rounded up to whole minutes")
+	assert.Equal(t, "2", ts.forms[0]["maxviews"])
+
+	code, out, _ = runCLI(t, "", "get", link)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "db password\n", out)
+
+	code, out, _ = runCLI(t, "", "get", link, "-o", "json")
+	assert.Equal(t, 0, code)
+	var result map[string]string
+	assert.NoError(t, json.Unmarshal([]byte(out), &result))
+	assert.Equal(t, "db password\n", result["secret"])
+
+	code, _, stderr = runCLI(t, "", "get", link)
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, "expired")
+}
+
+func TestCreateFromFileWithJSONOutput(t *testing.T) {
+	newTestServer(t)
+	file := filepath.Join(t.TempDir(), "creds.txt")
+	assert.NoError(t, os.WriteFile(file, []byte("from file"), 0o600))
+
+	code, out, stderr := runCLI(t, "", "create", file, "-o", "json", "--ttl", "2h")
+	assert.Equal(t, 0, code, stderr)
+	var result struct {
+		URL         string    `json:"url"`
+		ExpiresAt   time.Time `json:"expires_at"`
+		Encrypted   bool      `json:"encrypted"`
+		RevokeToken string    `json:"revoke_token"`
+	}
+	assert.NoError(t, json.Unmarshal([]byte(out), &result))
+	assert.WithinDuration(t, time.Now().Add(2*time.Hour), result.ExpiresAt, time.Minute)
+	assert.False(t, result.Encrypted)
+	assert.NotEmpty(t, result.RevokeToken)
+
+	_, out, _ = runCLI(t, "", "get", result.URL)
+	assert.Equal(t, "from file", out)
+}
+
+func TestClientSideEncryptionWithKey(t *testing.T) {
+	ts := newTestServer(t)
+
+	code, out, stderr := runCLI(t, "top secret", "create", "--encrypt", "--views", "2")
+	assert.Equal(t, 0, code, stderr)
+	link := strings.TrimSpace(out)
+	assert.Contains(t, link, "#")
+	assert.True(t, strings.HasPrefix(ts.forms[0]["secret"], keyPrefix))
+	assert.NotContains(t, ts.forms[0]["secret"], "top secret")
+
+	withoutKey, _, _ := strings.Cut(link, "#")
+	code, _, stderr = runCLI(t, "", "get", withoutKey)
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, "key")
+
+	code, out, _ = runCLI(t, "", "get", link)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "top secret", out)
+}
+
+func TestClientSideEncryptionWithPassphrase(t *testing.T) {
+	ts := newTestServer(t)
+	passFile := filepath.Join(t.TempDir(), "pass")
+	assert.NoError(t, os.WriteFile(passFile, []byte("correct horse\n"), 0o600))
+
+	code, out, stderr := runCLI(t, "guarded", "create", "--passphrase-file", passFile, "--views", "3")
+	assert.Equal(t, 0, code, stderr)
+	link := strings.TrimSpace(out)
+	assert.NotContains(t, link, "#")
+	assert.True(t, strings.HasPrefix(ts.forms[0]["secret"], passphrasePrefix))
+
+	code, _, stderr = runCLI(t, "", "get", link)
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, "passphrase")
+
+	code, _, stderr = runCLI(t, "", "get", link, "--passphrase", "wrong")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, "cannot decrypt")
+
+	t.Setenv(passphraseEnv, "correct horse")
+	code, out, _ = runCLI(t, "", "get", link)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "guarded", out)
+}
+
+func TestConfigIsSavedAndUsed(t *testing.T) {
+	ts := newTestServer(t)
+	t.Setenv(serverEnv, "")
+	path := filepath.Join(t.TempDir(), "nested", "client.yaml")
+
+	code, out, stderr := runCLI(t, "", "config", "--config", path, "--server", ts.URL, "--api-key", "sk-123456789")
+	assert.Equal(t, 0, code, stderr)
+	assert.Contains(t, out, "****6789")
+	assert.NotContains(t, out, "sk-123456789")
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	code, _, stderr = runCLI(t, "secret", "create", "--config", path)
+	assert.Equal(t, 0, code, stderr)
+	assert.Equal(t, "Bearer sk-123456789", ts.forms[0]["authorization"])
+}
+
+func TestUsageErrors(t *testing.T) {
+	newTestServer(t)
+
+	code, _, _ := runCLI(t, "", "create")
+	assert.Equal(t, 2, code, "empty secret")
+	code, _, _ = runCLI(t, "x", "create", "--encrypt", "--passphrase", "p")
+	assert.Equal(t, 2, code)
+	code, _, _ = runCLI(t, "", "get")
+	assert.Equal(t, 2, code)
+	code, _, _ = runCLI(t, "", "get", "not a link")
+	assert.Equal(t, 2, code)
+	code, _, _ = runCLI(t, "x", "create", "-o", "xml")
+	assert.Equal(t, 2, code)
+	code, _, _ = runCLI(t, "", "frobnicate")
+	assert.Equal(t, 2, code)
+}
+
+func TestGetRevealPageLink(t *testing.T) {
+	ts := newTestServer(t)
+
+	code, out, stderr := runCLI(t, "shared in the browser", "create", "--encrypt")
+	assert.Equal(t, 0, code, stderr)
+	link := strings.Replace(strings.TrimSpace(out), ts.URL+"/", ts.URL+"/s/", 1)
+
+	code, out, stderr = runCLI(t, "", "get", link)
+	assert.Equal(t, 0, code, stderr)
+	assert.Equal(t, "shared in the browser", out)
+}
+
+func TestCreateWritesQRCode(t *testing.T) {
+	newTestServer(t)
+	dir := t.TempDir()
+
+	code, out, stderr := runCLI(t, "wifi", "create", "--encrypt", "--qr", filepath.Join(dir, "link.svg"))
+	assert.Equal(t, 0, code, stderr)
+	assert.Contains(t, out, "#")
+	svg, err := os.ReadFile(filepath.Join(dir, "link.svg"))
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(svg), "<svg"))
+
+	code, _, stderr = runCLI(t, "wifi", "create", "--qr", filepath.Join(dir, "link.png"))
+	assert.Equal(t, 0, code, stderr)
+	png, err := os.ReadFile(filepath.Join(dir, "link.png"))
+	assert.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(png, []byte("\x89PNG")))
+
+	code, out, _ = runCLI(t, "wifi", "create", "--qr", "-")
+	assert.Equal(t, 0, code)
+	assert.Contains(t, out, "█")
+
+	code, _, _ = runCLI(t, "wifi", "create", "--qr", "-", "-o", "json")
+	assert.Equal(t, 2, code)
+}
+
+func TestCreateForRecipients(t *testing.T) {
+	ts := newTestServer(t)
+
+	code, out, stderr := runCLI(t, "db password", "create", "--to", "alice, bob", "--encrypt")
+	assert.Equal(t, 0, code, stderr)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	assert.Len(t, lines, 2)
+	alice, link, _ := strings.Cut(lines[0], "\t")
+	assert.Equal(t, "alice", alice)
+	assert.True(t, strings.HasPrefix(link, ts.URL+"/"), link)
+	assert.Contains(t, link, "#", "every link carries the key")
+	assert.Empty(t, ts.forms[0]["alias"])
+
+	code, out, _ = runCLI(t, "", "get", link)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "db password", out)
+
+	command := strings.Fields(strings.TrimPrefix(strings.TrimSpace(stderr), "see who opened their link: secretlinks "))
+	code, out, stderr = runCLI(t, "", command...)
+	assert.Equal(t, 0, code, stderr)
+	assert.Regexp(t, `alice +expired +1/1 +\d{4}-`, out)
+	assert.Regexp(t, `bob +active +0/1 +-`, out)
+
+	code, _, stderr = runCLI(t, "", "group", command[1], "-token", "wrong")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, "access denied")
+	code, _, _ = runCLI(t, "", "group", command[1])
+	assert.Equal(t, 2, code)
+	code, _, _ = runCLI(t, "x", "create", "--to", "alice", "--qr", "-")
+	assert.Equal(t, 2, code)
+}
@@ -0,0 +1,491 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/mail"
+	"net/netip"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is shared by the link server and the stats service. Every field
+// can be set in the YAML file, through its environment variable and with
+// its command-line flag; later sources win: defaults, file, environment,
+// flags.
+type Config struct {
+	Server  ServerConfig  `yaml:"server"`
+	Kafka   KafkaConfig   `yaml:"kafka"`
+	Stats   StatsConfig   `yaml:"stats"`
+	Tracing TracingConfig `yaml:"tracing"`
+	Notify  NotifyConfig  `yaml:"notify"`
+	KeyIDs  KeyIDsConfig  `yaml:"key_ids"`
+}
+
+type ServerConfig struct {
+	Addr              string `yaml:"addr" env:"SECRETLINKS_ADDR" flag:"addr" usage:"listen address of the link server"`
+	DefaultExpiration int    `yaml:"default_expiration" env:"SECRETLINKS_DEFAULT_EXPIRATION" flag:"default-expiration" usage:"expiration in minutes when /create gets none"`
+	DefaultMaxViews   int    `yaml:"default_max_views" env:"SECRETLINKS_DEFAULT_MAX_VIEWS" flag:"default-max-views" usage:"view limit when /create gets none"`
+	StatsEncoding     string `yaml:"stats_encoding" env:"SECRETLINKS_STATS_ENCODING" flag:"stats-encoding" usage:"encoding of stats events: json or protobuf"`
+	EventQueueSize    int    `yaml:"event_queue_size" env:"SECRETLINKS_EVENT_QUEUE_SIZE" flag:"event-queue-size" usage:"stats events, and separately webhook deliveries, buffered before new ones are dropped"`
+
+	SweepInterval   time.Duration `yaml:"sweep_interval" env:"SECRETLINKS_SWEEP_INTERVAL" flag:"sweep-interval" usage:"how often expired links are removed"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SECRETLINKS_SHUTDOWN_TIMEOUT" flag:"shutdown-timeout" usage:"how long to wait for in-flight requests and pending events on shutdown"`
+	Snapshot        string        `yaml:"snapshot" env:"SECRETLINKS_SNAPSHOT" flag:"snapshot" usage:"file the links are saved to on shutdown and loaded, then removed, on start; empty to disable. Anyone who can read it can read the secrets"`
+
+	BaseURL string `yaml:"base_url" env:"SECRETLINKS_BASE_URL" flag:"base-url" usage:"public URL of the service, e.g. https://example.com/secrets/; links are built from it and routes are served under its path"`
+
+	TLSCert        string        `yaml:"tls_cert" env:"SECRETLINKS_TLS_CERT" flag:"tls-cert" usage:"PEM certificate file; with tls-key the server listens with TLS and reloads the files when they change"`
+	TLSKey         string        `yaml:"tls_key" env:"SECRETLINKS_TLS_KEY" flag:"tls-key" usage:"PEM private key file"`
+	MetricsAddr    string        `yaml:"metrics_addr" env:"SECRETLINKS_METRICS_ADDR" flag:"metrics-addr" usage:"listen address of /metrics, empty to disable; it is not authenticated, keep it private"`
+	RedirectAddr   string        `yaml:"redirect_addr" env:"SECRETLINKS_REDIRECT_ADDR" flag:"redirect-addr" usage:"plain HTTP address that redirects to HTTPS, empty to disable"`
+	HSTSMaxAge     time.Duration `yaml:"hsts_max_age" env:"SECRETLINKS_HSTS_MAX_AGE" flag:"hsts-max-age" usage:"Strict-Transport-Security max-age sent over HTTPS, 0 to disable"`
+	TrustedProxies []string      `yaml:"trusted_proxies,omitempty" env:"SECRETLINKS_TRUSTED_PROXIES" flag:"trusted-proxies" usage:"comma-separated CIDRs or addresses of proxies whose X-Forwarded-* headers are trusted"`
+
+	APIKeys []string `yaml:"api_keys,omitempty" env:"SECRETLINKS_API_KEYS" flag:"api-keys" secret:"true" usage:"comma-separated API keys that authenticate callers; only authenticated callers may choose link aliases"`
+
+	WebhookLog          string        `yaml:"webhook_log" env:"SECRETLINKS_WEBHOOK_LOG" flag:"webhook-log" usage:"file every webhook delivery attempt is appended to, empty to keep only recent ones in memory"`
+	WebhookAttempts     int           `yaml:"webhook_attempts" env:"SECRETLINKS_WEBHOOK_ATTEMPTS" flag:"webhook-attempts" usage:"how many times a webhook is tried before it is given up"`
+	WebhookTimeout      time.Duration `yaml:"webhook_timeout" env:"SECRETLINKS_WEBHOOK_TIMEOUT" flag:"webhook-timeout" usage:"how long a single webhook request may take"`
+	WebhookAllowPrivate bool          `yaml:"webhook_allow_private" env:"SECRETLINKS_WEBHOOK_ALLOW_PRIVATE" flag:"webhook-allow-private" usage:"allow webhooks to loopback and private addresses, e.g. for local development"`
+}
+
+// TLS reports whether the link server listens with TLS.
+func (s ServerConfig) TLS() bool {
+	return s.TLSCert != "" && s.TLSKey != ""
+}
+
+// NotifyConfig enables email notifications to link creators: through a
+// mail server with SMTPAddr, or written as files into Dir.
+type NotifyConfig struct {
+	From         string `yaml:"from" env:"SECRETLINKS_NOTIFY_FROM" flag:"notify-from" usage:"sender address of notification emails"`
+	SMTPAddr     string `yaml:"smtp_addr" env:"SECRETLINKS_SMTP_ADDR" flag:"smtp-addr" usage:"mail server (host:port) notification emails are sent through, empty to disable"`
+	SMTPUsername string `yaml:"smtp_username" env:"SECRETLINKS_SMTP_USERNAME" flag:"smtp-username" usage:"mail server user name, empty to send without authentication"`
+	SMTPPassword string `yaml:"smtp_password" env:"SECRETLINKS_SMTP_PASSWORD" flag:"smtp-password" secret:"true" usage:"mail server password"`
+	Dir          string `yaml:"dir" env:"SECRETLINKS_NOTIFY_DIR" flag:"notify-dir" usage:"directory notification emails are written to as .eml files instead of being sent, for local development"`
+}
+
+// Enabled reports whether email notifications are configured.
+func (n NotifyConfig) Enabled() bool {
+	return n.SMTPAddr != "" || n.Dir != ""
+}
+
+type KafkaConfig struct {
+	Brokers []string     `yaml:"brokers" env:"SECRETLINKS_KAFKA_BROKERS" flag:"kafka-brokers" usage:"comma-separated kafka brokers"`
+	GroupID string       `yaml:"group_id" env:"SECRETLINKS_KAFKA_GROUP_ID" flag:"kafka-group-id" usage:"consumer group of the stats service"`
+	Topics  TopicsConfig `yaml:"topics"`
+}
+
+type TopicsConfig struct {
+	NewLinks     string `yaml:"new_links" env:"SECRETLINKS_TOPIC_NEW_LINKS" flag:"topic-new-links" usage:"topic for created links"`
+	UpdateLinks  string `yaml:"update_links" env:"SECRETLINKS_TOPIC_UPDATE_LINKS" flag:"topic-update-links" usage:"topic for link views"`
+	ExpiredLinks string `yaml:"expired_links" env:"SECRETLINKS_TOPIC_EXPIRED_LINKS" flag:"topic-expired-links" usage:"topic for expired links"`
+	RevokedLinks string `yaml:"revoked_links" env:"SECRETLINKS_TOPIC_REVOKED_LINKS" flag:"topic-revoked-links" usage:"topic for revoked links"`
+	DeniedLinks  string `yaml:"denied_links" env:"SECRETLINKS_TOPIC_DENIED_LINKS" flag:"topic-denied-links" usage:"topic for views refused to addresses outside a link's networks"`
+}
+
+type StatsConfig struct {
+	Addr       string          `yaml:"addr" env:"SECRETLINKS_STATS_ADDR" flag:"stats-addr" usage:"listen address of the stats HTTP API and /metrics, empty to disable; the API is not authenticated, keep it private"`
+	DB         string          `yaml:"db" env:"SECRETLINKS_STATS_DB" flag:"stats-db" usage:"stats database file, empty to keep statistics in memory only"`
+	DeadLetter string          `yaml:"dead_letter" env:"SECRETLINKS_STATS_DEAD_LETTER" flag:"stats-dead-letter" usage:"file for messages that could not be processed"`
+	Retention  RetentionConfig `yaml:"retention"`
+}
+
+type RetentionConfig struct {
+	Minute time.Duration `yaml:"minute" env:"SECRETLINKS_RETENTION_MINUTE" flag:"retention-minute" usage:"how long per-minute buckets are kept before folding into hours"`
+	Hour   time.Duration `yaml:"hour" env:"SECRETLINKS_RETENTION_HOUR" flag:"retention-hour" usage:"how long per-hour buckets are kept before folding into days"`
+	Day    time.Duration `yaml:"day" env:"SECRETLINKS_RETENTION_DAY" flag:"retention-day" usage:"how long per-day buckets are kept"`
+}
+
+// KeyIDsConfig keys the ids link keys are replaced with in logs, traces,
+// metrics, the stats API and notification emails.
+type KeyIDsConfig struct {
+	Secret string `yaml:"secret" env:"SECRETLINKS_KEY_ID_SECRET" flag:"key-id-secret" secret:"true" usage:"secret the ids of link keys are derived from; give the link server and the stats service the same one to match ids between them, empty for a random one per process"`
+}
+
+type TracingConfig struct {
+	Exporter string `yaml:"exporter" env:"SECRETLINKS_TRACE_EXPORTER" flag:"trace-exporter" usage:"where to send traces: none, stdout or otlp"`
+}
+
+// ConfigEnv names the config file when -config is not given.
+const ConfigEnv = "SECRETLINKS_CONFIG"
+
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			Addr:              ":8080",
+			MetricsAddr:       "127.0.0.1:9090",
+			DefaultExpiration: 60,
+			DefaultMaxViews:   1,
+			StatsEncoding:     "json",
+			EventQueueSize:    1000,
+			SweepInterval:     time.Minute,
+			ShutdownTimeout:   15 * time.Second,
+			HSTSMaxAge:        365 * 24 * time.Hour,
+			WebhookAttempts:   5,
+			WebhookTimeout:    10 * time.Second,
+		},
+		Kafka: KafkaConfig{
+			Brokers: []string{"localhost:9092"},
+			GroupID: "links-group",
+			Topics: TopicsConfig{
+				NewLinks:     "newlinks",
+				UpdateLinks:  "updatelinks",
+				ExpiredLinks: "expiredlinks",
+				RevokedLinks: "revokedlinks",
+				DeniedLinks:  "deniedlinks",
+			},
+		},
+		Stats: StatsConfig{
+			Addr:       "127.0.0.1:8081",
+			DB:         "stats.db",
+			DeadLetter: "stats-deadletter.jsonl",
+			Retention: RetentionConfig{
+				Minute: 6 * time.Hour,
+				Hour:   30 * 24 * time.Hour,
+				Day:    2 * 365 * 24 * time.Hour,
+			},
+		},
+		Tracing: TracingConfig{
+			Exporter: "none",
+		},
+	}
+}
+
+// ErrPrintConfig is returned by Load after -print-config wrote the
+// configuration; the caller should exit successfully.
+var ErrPrintConfig = errors.New("configuration printed")
+
+// Load builds the configuration of a binary from args (without the
+// program name) and the environment, then validates it. With
+// -print-config the effective configuration is written to out and
+// ErrPrintConfig is returned.
+func Load(name string, args []string, out io.Writer) (Config, error) {
+	cfg := Default()
+	fields := collectFields(&cfg)
+
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(out)
+	configPath := flags.String("config", "", "YAML configuration file (default $"+ConfigEnv+")")
+	printConfig := flags.Bool("print-config", false, "print the effective configuration and exit")
+	values := make(map[string]*string, len(fields))
+	for _, f := range fields {
+		raw := new(string)
+		values[f.flag] = raw
+		usage := f.usage + " (env " + f.env + ", default " + f.String() + ")"
+		setRaw := func(s string) error {
+			*raw = s
+			return nil
+		}
+		if f.value.Kind() == reflect.Bool {
+			flags.BoolFunc(f.flag, usage, setRaw)
+		} else {
+			flags.Func(f.flag, usage, setRaw)
+		}
+	}
+	if err := flags.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	if *configPath == "" {
+		*configPath = os.Getenv(ConfigEnv)
+	}
+	if *configPath != "" {
+		if err := loadFile(&cfg, *configPath); err != nil {
+			return cfg, err
+		}
+	}
+
+	for _, f := range fields {
+		if value, ok := os.LookupEnv(f.env); ok {
+			if err := f.set(value); err != nil {
+				return cfg, fmt.Errorf("%s: %w", f.env, err)
+			}
+		}
+	}
+
+	var flagErr error
+	flags.Visit(func(fl *flag.Flag) {
+		raw, ok := values[fl.Name]
+		if !ok || flagErr != nil {
+			return
+		}
+		for _, f := range fields {
+			if f.flag == fl.Name {
+				if err := f.set(*raw); err != nil {
+					flagErr = fmt.Errorf("-%s: %w", fl.Name, err)
+				}
+			}
+		}
+	})
+	if flagErr != nil {
+		return cfg, flagErr
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return cfg, err
+	}
+	if *printConfig {
+		if err := yaml.NewEncoder(out).Encode(cfg.Redacted()); err != nil {
+			return cfg, err
+		}
+		return cfg, ErrPrintConfig
+	}
+	return cfg, nil
+}
+
+// loadFile reads the YAML file at path into cfg. Unknown keys are errors,
+// so a misspelt setting is not silently left at its default.
+func loadFile(cfg *Config, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+func (c Config) Validate() error {
+	var errs []error
+	if c.Server.Addr == "" {
+		errs = append(errs, errors.New("server.addr must not be empty"))
+	}
+	if c.Server.DefaultExpiration <= 0 {
+		errs = append(errs, errors.New("server.default_expiration must be positive"))
+	}
+	if c.Server.DefaultMaxViews <= 0 {
+		errs = append(errs, errors.New("server.default_max_views must be positive"))
+	}
+	switch c.Server.StatsEncoding {
+	case "json", "proto", "protobuf":
+	default:
+		errs = append(errs, fmt.Errorf("server.stats_encoding %q must be json or protobuf", c.Server.StatsEncoding))
+	}
+	if c.Server.EventQueueSize <= 0 {
+		errs = append(errs, errors.New("server.event_queue_size must be positive"))
+	}
+	if c.Server.SweepInterval <= 0 {
+		errs = append(errs, errors.New("server.sweep_interval must be positive"))
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("server.shutdown_timeout must be positive"))
+	}
+	if c.Server.BaseURL != "" {
+		u, err := url.Parse(c.Server.BaseURL)
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Errorf("server.base_url: %w", err))
+		case u.Scheme != "http" && u.Scheme != "https", u.Host == "":
+			errs = append(errs, fmt.Errorf("server.base_url %q must be an absolute http or https URL", c.Server.BaseURL))
+		case u.User != nil, u.RawQuery != "", u.Fragment != "":
+			errs = append(errs, fmt.Errorf("server.base_url %q must not have credentials, a query or a fragment", c.Server.BaseURL))
+		}
+	}
+	if c.Server.HSTSMaxAge < 0 {
+		errs = append(errs, errors.New("server.hsts_max_age must not be negative"))
+	}
+	if (c.Server.TLSCert == "") != (c.Server.TLSKey == "") {
+		errs = append(errs, errors.New("server.tls_cert and server.tls_key must be set together"))
+	}
+	if c.Server.RedirectAddr != "" && !c.Server.TLS() {
+		errs = append(errs, errors.New("server.redirect_addr requires server.tls_cert and server.tls_key"))
+	}
+	for _, proxy := range c.Server.TrustedProxies {
+		if _, err := netip.ParsePrefix(proxy); err == nil {
+			continue
+		}
+		if _, err := netip.ParseAddr(proxy); err != nil {
+			errs = append(errs, fmt.Errorf("server.trusted_proxies: %q is neither an address nor a CIDR", proxy))
+		}
+	}
+	if c.Server.WebhookAttempts <= 0 {
+		errs = append(errs, errors.New("server.webhook_attempts must be positive"))
+	}
+	if c.Server.WebhookTimeout <= 0 {
+		errs = append(errs, errors.New("server.webhook_timeout must be positive"))
+	}
+	for _, key := range c.Server.APIKeys {
+		if key == "" {
+			errs = append(errs, errors.New("server.api_keys must not contain empty entries"))
+			break
+		}
+	}
+
+	if c.Notify.SMTPAddr != "" && c.Notify.Dir != "" {
+		errs = append(errs, errors.New("notify.smtp_addr and notify.dir must not be set together"))
+	}
+	if c.Notify.SMTPAddr != "" {
+		if _, _, err := net.SplitHostPort(c.Notify.SMTPAddr); err != nil {
+			errs = append(errs, fmt.Errorf("notify.smtp_addr: %w", err))
+		}
+	}
+	if c.Notify.Enabled() {
+		if _, err := mail.ParseAddress(c.Notify.From); err != nil {
+			errs = append(errs, fmt.Errorf("notify.from %q must be an email address", c.Notify.From))
+		}
+	}
+
+	if len(c.Kafka.Brokers) == 0 {
+		errs = append(errs, errors.New("kafka.brokers must not be empty"))
+	}
+	for _, broker := range c.Kafka.Brokers {
+		if broker == "" {
+			errs = append(errs, errors.New("kafka.brokers must not contain empty entries"))
+			break
+		}
+	}
+	if c.Kafka.GroupID == "" {
+		errs = append(errs, errors.New("kafka.group_id must not be empty"))
+	}
+	topics := map[string]bool{}
+	for _, topic := range c.Kafka.Topics.All() {
+		if topic == "" {
+			errs = append(errs, errors.New("kafka.topics must not be empty"))
+		} else if topics[topic] {
+			errs = append(errs, fmt.Errorf("kafka topic %q is used twice", topic))
+		}
+		topics[topic] = true
+	}
+
+	r := c.Stats.Retention
+	if r.Minute <= 0 || r.Hour <= 0 || r.Day <= 0 {
+		errs = append(errs, errors.New("stats.retention durations must be positive"))
+	} else if r.Minute > r.Hour || r.Hour > r.Day {
+		errs = append(errs, errors.New("stats.retention must not shrink from minute to hour to day"))
+	}
+
+	switch c.Tracing.Exporter {
+	case "", "none", "stdout", "otlp":
+	default:
+		errs = append(errs, fmt.Errorf("tracing.exporter %q must be none, stdout or otlp", c.Tracing.Exporter))
+	}
+	return errors.Join(errs...)
+}
+
+func (t TopicsConfig) All() []string {
+	return []string{t.NewLinks, t.UpdateLinks, t.ExpiredLinks, t.RevokedLinks, t.DeniedLinks}
+}
+
+// redactedValue is what is printed for a set secret.
+const redactedValue = "REDACTED"
+
+// Redacted returns a copy of c with every field tagged secret:"true" that
+// is set replaced by redactedValue, for printing.
+func (c Config) Redacted() Config {
+	for _, f := range collectFields(&c) {
+		if !f.secret {
+			continue
+		}
+		switch v := f.value.Addr().Interface().(type) {
+		case *string:
+			if *v != "" {
+				*v = redactedValue
+			}
+		case *[]string:
+			// The copy shares the slice's array with c.
+			masked := make([]string, len(*v))
+			for i := range masked {
+				masked[i] = redactedValue
+			}
+			*v = masked
+		}
+	}
+	return c
+}
+
+// field is a leaf of Config that can be set from a string.
+type field struct {
+	value  reflect.Value
+	env    string
+	flag   string
+	usage  string
+	secret bool
+}
+
+func collectFields(cfg *Config) []field {
+	var fields []field
+	var walk func(v reflect.Value)
+	walk = func(v reflect.Value) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			fv := v.Field(i)
+			if sf.Tag.Get("flag") == "" {
+				walk(fv)
+				continue
+			}
+			fields = append(fields, field{
+				value:  fv,
+				env:    sf.Tag.Get("env"),
+				flag:   sf.Tag.Get("flag"),
+				usage:  sf.Tag.Get("usage"),
+				secret: sf.Tag.Get("secret") == "true",
+			})
+		}
+	}
+	walk(reflect.ValueOf(cfg).Elem())
+	return fields
+}
+
+func (f field) set(s string) error {
+	switch v := f.value.Addr().Interface().(type) {
+	case *string:
+		*v = s
+	case *bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		*v = b
+	case *int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		*v = n
+	case *time.Duration:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		*v = d
+	case *[]string:
+		*v = nil
+		for _, part := range strings.Split(s, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				*v = append(*v, part)
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported type %s", f.value.Type())
+	}
+	return nil
+}
+
+func (f field) String() string {
+	switch v := f.value.Interface().(type) {
+	case []string:
+		return strings.Join(v, ",")
+	case string:
+		if v == "" {
+			return `""`
+		}
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,161 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestDefaultIsValid(t *testing.T) {
+	require.NoError(t, Default().Validate(), "default configuration is invalid")
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	file := `
+server:
+  addr: ":9000"
+  default_max_views: 3
+kafka:
+  brokers: [file-1:9092, file-2:9092]
+  group_id: from-file
+stats:
+  retention:
+    minute: 2h
+`
+	require.NoError(t, os.WriteFile(path, []byte(file), 0o600))
+	t.Setenv(ConfigEnv, path)
+	t.Setenv("SECRETLINKS_ADDR", ":9100")
+	t.Setenv("SECRETLINKS_KAFKA_GROUP_ID", "from-env")
+
+	cfg, err := Load("test", []string{"-addr", ":9200", "-retention-hour", "48h"}, &bytes.Buffer{})
+	require.NoError(t, err)
+
+	assert.Equal(t, ":9200", cfg.Server.Addr, "the flag wins")
+	assert.Equal(t, "from-env", cfg.Kafka.GroupID, "the environment wins over the file")
+	assert.Equal(t, 3, cfg.Server.DefaultMaxViews, "the file wins over the default")
+	assert.Equal(t, []string{"file-1:9092", "file-2:9092"}, cfg.Kafka.Brokers)
+	assert.Equal(t, 2*time.Hour, cfg.Stats.Retention.Minute)
+	assert.Equal(t, 48*time.Hour, cfg.Stats.Retention.Hour)
+	assert.Equal(t, Default().Server.DefaultExpiration, cfg.Server.DefaultExpiration)
+}
+
+func TestLoadRejectsUnknownKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("server:\n  adr: \":9000\"\n"), 0o600))
+	t.Setenv(ConfigEnv, path)
+
+	_, err := Load("test", nil, &bytes.Buffer{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "adr")
+}
+
+func TestLoadEmptyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, nil, 0o600))
+	t.Setenv(ConfigEnv, path)
+
+	cfg, err := Load("test", nil, &bytes.Buffer{})
+	require.NoError(t, err)
+	assert.Equal(t, Default(), cfg)
+}
+
+func TestLoadBool(t *testing.T) {
+	cfg, err := Load("test", []string{"-webhook-allow-private"}, &bytes.Buffer{})
+	require.NoError(t, err)
+	assert.True(t, cfg.Server.WebhookAllowPrivate, "a bare boolean flag sets the value")
+
+	t.Setenv("SECRETLINKS_WEBHOOK_ALLOW_PRIVATE", "true")
+	cfg, err = Load("test", []string{"-webhook-allow-private=false"}, &bytes.Buffer{})
+	require.NoError(t, err)
+	assert.False(t, cfg.Server.WebhookAllowPrivate, "the flag overrides the environment")
+}
+
+func TestLoadListFromEnvironment(t *testing.T) {
+	t.Setenv("SECRETLINKS_KAFKA_BROKERS", "a:9092, b:9092,")
+
+	cfg, err := Load("test", nil, &bytes.Buffer{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a:9092", "b:9092"}, cfg.Kafka.Brokers)
+}
+
+func TestLoadRejectsInvalidValues(t *testing.T) {
+	tests := map[string][]string{
+		"bad number":   {"-default-max-views", "many"},
+		"bad duration": {"-retention-day", "forever"},
+		"zero views":   {"-default-max-views", "0"},
+		"encoding":     {"-stats-encoding", "xml"},
+		"exporter":     {"-trace-exporter", "jaeger"},
+		"same topic":   {"-topic-update-links", "newlinks"},
+		"retention":    {"-retention-minute", "48h", "-retention-hour", "24h"},
+		"unknown flag": {"-does-not-exist", "1"},
+		"tls pair":     {"-tls-cert", "cert.pem"},
+		"redirect":     {"-redirect-addr", ":80"},
+		"proxy":        {"-trusted-proxies", "10.0.0.0/8,proxy.local"},
+		"relative url": {"-base-url", "/secrets/"},
+		"url query":    {"-base-url", "https://example.com/?x=1"},
+		"bad bool":     {"-webhook-allow-private=maybe"},
+		"no attempts":  {"-webhook-attempts", "0"},
+		"smtp no from": {"-smtp-addr", "mail.example.com:587"},
+		"smtp port":    {"-smtp-addr", "mail.example.com", "-notify-from", "links@example.com"},
+		"two channels": {"-smtp-addr", "mail.example.com:587", "-notify-dir", "mail", "-notify-from", "links@example.com"},
+	}
+	for name, args := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Load("test", args, &bytes.Buffer{})
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	cfg := Default()
+	cfg.Server.Addr = ""
+	cfg.Kafka.Brokers = nil
+	cfg.Tracing.Exporter = "jaeger"
+	cfg.Server.APIKeys = []string{""}
+
+	err := cfg.Validate()
+	require.Error(t, err)
+	for _, want := range []string{"server.addr", "server.api_keys", "kafka.brokers", "tracing.exporter"} {
+		assert.Contains(t, err.Error(), want)
+	}
+}
+
+func TestPrintConfigRoundTrips(t *testing.T) {
+	var out bytes.Buffer
+	_, err := Load("test", []string{"-print-config", "-retention-minute", "90m"}, &out)
+	require.ErrorIs(t, err, ErrPrintConfig)
+	assert.Contains(t, out.String(), "minute: 1h30m0s", "durations are printed readably")
+
+	var printed Config
+	require.NoError(t, yaml.Unmarshal(out.Bytes(), &printed))
+	want := Default()
+	want.Stats.Retention.Minute = 90 * time.Minute
+	assert.Equal(t, want, printed)
+}
+
+func TestPrintConfigHidesSecrets(t *testing.T) {
+	t.Setenv("SECRETLINKS_API_KEYS", "sk-live-123,sk-live-456")
+	t.Setenv("SECRETLINKS_SMTP_PASSWORD", "hunter2")
+	t.Setenv("SECRETLINKS_KEY_ID_SECRET", "idsecret")
+
+	var out bytes.Buffer
+	cfg, err := Load("test", []string{"-print-config"}, &out)
+	require.ErrorIs(t, err, ErrPrintConfig)
+	for _, secret := range []string{"sk-live-123", "sk-live-456", "hunter2", "idsecret"} {
+		assert.NotContains(t, out.String(), secret)
+	}
+	assert.Contains(t, out.String(), "smtp_password: "+redactedValue)
+
+	var printed Config
+	require.NoError(t, yaml.Unmarshal(out.Bytes(), &printed))
+	assert.Equal(t, []string{redactedValue, redactedValue}, printed.Server.APIKeys)
+	assert.Equal(t, []string{"sk-live-123", "sk-live-456"}, cfg.Server.APIKeys, "the loaded configuration keeps them")
+}
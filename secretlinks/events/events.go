@@ -0,0 +1,236 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	ContentTypeHeader   = "content-type"
+	ContentTypeJSON     = "application/json"
+	ContentTypeProtobuf = "application/x-protobuf"
+)
+
+// Reasons carried by "expiredlinks" events.
+const (
+	ReasonTime  = "time"
+	ReasonViews = "views"
+)
+
+// LinkEvent is the payload of every stats message, see events.proto.
+// ExpiresAt and MaxViews are only set on "newlinks", Reason only on
+// "expiredlinks".
+type LinkEvent struct {
+	LinkKey   string     `json:"linkkey"`
+	NowTime   time.Time  `json:"nowtime"`
+	ExpiresAt *time.Time `json:"expiresat,omitempty"`
+	MaxViews  int        `json:"maxviews,omitempty"`
+	Reason    string     `json:"reason,omitempty"`
+}
+
+// ParseEncoding maps a short encoding name ("json", "protobuf") to a content type.
+func ParseEncoding(name string) (string, error) {
+	switch name {
+	case "", "json":
+		return ContentTypeJSON, nil
+	case "proto", "protobuf":
+		return ContentTypeProtobuf, nil
+	}
+	return "", fmt.Errorf("unknown stats encoding %q", name)
+}
+
+func Marshal(event LinkEvent, contentType string) ([]byte, error) {
+	switch contentType {
+	case ContentTypeJSON:
+		return json.Marshal(event)
+	case ContentTypeProtobuf:
+		return marshalProto(event), nil
+	}
+	return nil, fmt.Errorf("unsupported content type %q", contentType)
+}
+
+func Unmarshal(data []byte, contentType string, event *LinkEvent) error {
+	switch contentType {
+	case ContentTypeJSON:
+		return json.Unmarshal(data, event)
+	case ContentTypeProtobuf:
+		return unmarshalProto(data, event)
+	}
+	return fmt.Errorf("unsupported content type %q", contentType)
+}
+
+// NewMessage builds a kafka message for the event tagged with its content type.
+func NewMessage(event LinkEvent, contentType string) (kafka.Message, error) {
+	value, err := Marshal(event, contentType)
+	if err != nil {
+		return kafka.Message{}, err
+	}
+	return kafka.Message{
+		Key:   []byte(event.LinkKey),
+		Value: value,
+		Headers: []kafka.Header{
+			{Key: ContentTypeHeader, Value: []byte(contentType)},
+		},
+	}, nil
+}
+
+// ContentType returns the content type of a message. Messages without
+// the header were written before protobuf support and are JSON.
+func ContentType(msg kafka.Message) string {
+	for _, h := range msg.Headers {
+		if h.Key == ContentTypeHeader {
+			return string(h.Value)
+		}
+	}
+	return ContentTypeJSON
+}
+
+func Decode(msg kafka.Message) (LinkEvent, error) {
+	var event LinkEvent
+	err := Unmarshal(msg.Value, ContentType(msg), &event)
+	return event, err
+}
+
+const (
+	fieldLinkKey   = 1
+	fieldNowTime   = 2
+	fieldExpiresAt = 3
+	fieldMaxViews  = 4
+	fieldReason    = 5
+
+	fieldSeconds = 1
+	fieldNanos   = 2
+)
+
+func marshalProto(event LinkEvent) []byte {
+	var b []byte
+	if event.LinkKey != "" {
+		b = protowire.AppendTag(b, fieldLinkKey, protowire.BytesType)
+		b = protowire.AppendString(b, event.LinkKey)
+	}
+	if !event.NowTime.IsZero() {
+		b = protowire.AppendTag(b, fieldNowTime, protowire.BytesType)
+		b = protowire.AppendBytes(b, marshalTimestamp(event.NowTime))
+	}
+	if event.ExpiresAt != nil {
+		b = protowire.AppendTag(b, fieldExpiresAt, protowire.BytesType)
+		b = protowire.AppendBytes(b, marshalTimestamp(*event.ExpiresAt))
+	}
+	if event.MaxViews != 0 {
+		b = protowire.AppendTag(b, fieldMaxViews, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(int64(event.MaxViews)))
+	}
+	if event.Reason != "" {
+		b = protowire.AppendTag(b, fieldReason, protowire.BytesType)
+		b = protowire.AppendString(b, event.Reason)
+	}
+	return b
+}
+
+func marshalTimestamp(t time.Time) []byte {
+	var b []byte
+	if s := t.Unix(); s != 0 {
+		b = protowire.AppendTag(b, fieldSeconds, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(s))
+	}
+	if n := t.Nanosecond(); n != 0 {
+		b = protowire.AppendTag(b, fieldNanos, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(n))
+	}
+	return b
+}
+
+func unmarshalProto(b []byte, event *LinkEvent) error {
+	*event = LinkEvent{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		switch {
+		case num == fieldLinkKey && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			event.LinkKey = v
+			b = b[n:]
+		case (num == fieldNowTime || num == fieldExpiresAt) && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			t, err := unmarshalTimestamp(v)
+			if err != nil {
+				return err
+			}
+			if num == fieldNowTime {
+				event.NowTime = t
+			} else {
+				event.ExpiresAt = &t
+			}
+			b = b[n:]
+		case num == fieldMaxViews && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			event.MaxViews = int(int64(v))
+			b = b[n:]
+		case num == fieldReason && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			event.Reason = v
+			b = b[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+		}
+	}
+	if event.LinkKey == "" {
+		return errors.New("protobuf event without link_key")
+	}
+	return nil
+}
+
+func unmarshalTimestamp(b []byte) (time.Time, error) {
+	var seconds, nanos int64
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return time.Time{}, protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ != protowire.VarintType || (num != fieldSeconds && num != fieldNanos) {
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return time.Time{}, protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+		v, n := protowire.ConsumeVarint(b)
+		if n < 0 {
+			return time.Time{}, protowire.ParseError(n)
+		}
+		if num == fieldSeconds {
+			seconds = int64(v)
+		} else {
+			nanos = int64(int32(v))
+		}
+		b = b[n:]
+	}
+	return time.Unix(seconds, nanos), nil
+}
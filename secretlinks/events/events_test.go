@@ -0,0 +1,184 @@
+package events
+
+import (
+	"os"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestProtobufRoundTrip(t *testing.T) {
+	event := LinkEvent{LinkKey: "AbCdEfGh", NowTime: time.Now()}
+
+	msg, err := NewMessage(event, ContentTypeProtobuf)
+	assert.NoError(t, err)
+	assert.Equal(t, ContentTypeProtobuf, ContentType(msg))
+
+	decoded, err := Decode(msg)
+	assert.NoError(t, err)
+	assert.Equal(t, event.LinkKey, decoded.LinkKey)
+	assert.True(t, event.NowTime.Equal(decoded.NowTime))
+}
+
+func TestProtobufRoundTripAllFields(t *testing.T) {
+	expiresAt := time.Now().Add(time.Hour)
+	event := LinkEvent{
+		LinkKey:   "AbCdEfGh",
+		NowTime:   time.Now(),
+		ExpiresAt: &expiresAt,
+		MaxViews:  3,
+		Reason:    ReasonViews,
+	}
+
+	data, err := Marshal(event, ContentTypeProtobuf)
+	assert.NoError(t, err)
+
+	var decoded LinkEvent
+	assert.NoError(t, Unmarshal(data, ContentTypeProtobuf, &decoded))
+	require.NotNil(t, decoded.ExpiresAt)
+	assert.True(t, expiresAt.Equal(*decoded.ExpiresAt))
+	assert.Equal(t, 3, decoded.MaxViews)
+	assert.Equal(t, ReasonViews, decoded.Reason)
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	event := LinkEvent{LinkKey: "AbCdEfGh", NowTime: time.Now()}
+
+	msg, err := NewMessage(event, ContentTypeJSON)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(msg.Value), "expiresat", "only new links carry an expiration")
+
+	decoded, err := Decode(msg)
+	assert.NoError(t, err)
+	assert.Equal(t, event.LinkKey, decoded.LinkKey)
+	assert.True(t, event.NowTime.Equal(decoded.NowTime))
+	assert.Nil(t, decoded.ExpiresAt)
+}
+
+func TestDecodeWithoutHeader(t *testing.T) {
+	msg := kafka.Message{Value: []byte(`{"linkkey":"AbCdEfGh","nowtime":"2025-07-15T12:22:12Z"}`)}
+
+	decoded, err := Decode(msg)
+	assert.NoError(t, err)
+	assert.Equal(t, "AbCdEfGh", decoded.LinkKey)
+}
+
+func TestDecodeInvalidProtobuf(t *testing.T) {
+	msg := kafka.Message{
+		Value:   []byte{0xff, 0xff},
+		Headers: []kafka.Header{{Key: ContentTypeHeader, Value: []byte(ContentTypeProtobuf)}},
+	}
+
+	_, err := Decode(msg)
+	assert.Error(t, err)
+}
+
+func TestParseEncoding(t *testing.T) {
+	contentType, err := ParseEncoding("protobuf")
+	assert.NoError(t, err)
+	assert.Equal(t, ContentTypeProtobuf, contentType)
+
+	_, err = ParseEncoding("xml")
+	assert.Error(t, err)
+}
+
+var protoField = regexp.MustCompile(`(?m)^\s*([\w.]+)\s+(\w+)\s*=\s*(\d+)\s*;`)
+
+// linkEventDescriptor builds the LinkEvent descriptor from the fields
+// declared in events.proto, so the codec is checked against the schema
+// file itself rather than against a copy of it.
+func linkEventDescriptor(t *testing.T) protoreflect.MessageDescriptor {
+	t.Helper()
+	schema, err := os.ReadFile("events.proto")
+	require.NoError(t, err)
+
+	types := map[string]*descriptorpb.FieldDescriptorProto{
+		"string": {Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()},
+		"int64":  {Type: descriptorpb.FieldDescriptorProto_TYPE_INT64.Enum()},
+		"google.protobuf.Timestamp": {
+			Type:     descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+			TypeName: proto.String(".google.protobuf.Timestamp"),
+		},
+	}
+	message := &descriptorpb.DescriptorProto{Name: proto.String("LinkEvent")}
+	for _, m := range protoField.FindAllStringSubmatch(string(schema), -1) {
+		typ, ok := types[m[1]]
+		require.True(t, ok, "unsupported type %s of field %s", m[1], m[2])
+		field := proto.Clone(typ).(*descriptorpb.FieldDescriptorProto)
+		field.Name = proto.String(m[2])
+		field.JsonName = proto.String(m[2])
+		number, err := strconv.Atoi(m[3])
+		require.NoError(t, err)
+		field.Number = proto.Int32(int32(number))
+		field.Label = descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()
+		message.Field = append(message.Field, field)
+	}
+	require.NotEmpty(t, message.Field, "no fields found in events.proto")
+
+	file, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:        proto.String("events.proto"),
+		Package:     proto.String("secretlinks.events"),
+		Syntax:      proto.String("proto3"),
+		Dependency:  []string{timestamppb.File_google_protobuf_timestamp_proto.Path()},
+		MessageType: []*descriptorpb.DescriptorProto{message},
+	}, protoregistry.GlobalFiles)
+	require.NoError(t, err)
+	return file.Messages().ByName("LinkEvent")
+}
+
+func TestProtobufMatchesSchema(t *testing.T) {
+	desc := linkEventDescriptor(t)
+	nowTime := time.Unix(1752582132, 123456789)
+	expiresAt := time.Unix(1752585732, 987654321)
+	event := LinkEvent{
+		LinkKey:   "AbCdEfGh",
+		NowTime:   nowTime,
+		ExpiresAt: &expiresAt,
+		MaxViews:  3,
+		Reason:    ReasonViews,
+	}
+	want := map[protoreflect.Name]protoreflect.Value{
+		"link_key":   protoreflect.ValueOfString(event.LinkKey),
+		"now_time":   protoreflect.ValueOfMessage(timestamppb.New(nowTime).ProtoReflect()),
+		"expires_at": protoreflect.ValueOfMessage(timestamppb.New(expiresAt).ProtoReflect()),
+		"max_views":  protoreflect.ValueOfInt64(int64(event.MaxViews)),
+		"reason":     protoreflect.ValueOfString(event.Reason),
+	}
+	fields := desc.Fields()
+	require.Equal(t, len(want), fields.Len(), "every field of events.proto is covered")
+
+	expected := dynamicpb.NewMessage(desc)
+	for name, value := range want {
+		field := fields.ByName(name)
+		require.NotNil(t, field, "events.proto declares %s", name)
+		expected.Set(field, value)
+	}
+
+	t.Run("encode", func(t *testing.T) {
+		data, err := Marshal(event, ContentTypeProtobuf)
+		require.NoError(t, err)
+		decoded := dynamicpb.NewMessage(desc)
+		require.NoError(t, proto.Unmarshal(data, decoded))
+		assert.True(t, proto.Equal(expected, decoded), "got %v, want %v", decoded, expected)
+	})
+
+	t.Run("decode", func(t *testing.T) {
+		data, err := proto.Marshal(expected)
+		require.NoError(t, err)
+		var decoded LinkEvent
+		require.NoError(t, Unmarshal(data, ContentTypeProtobuf, &decoded))
+		assert.Equal(t, event, decoded)
+	})
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// maxAllowedNets bounds the networks a single link may list.
+const maxAllowedNets = 32
+
+// ErrLinkDenied is returned by openLink when the client address is outside
+// the networks the link is restricted to.
+var ErrLinkDenied = errors.New("link not available from this address")
+
+// parseAllowedNets reads the "allow" form values: CIDR ranges or single
+// addresses, comma-separated or repeated.
+func parseAllowedNets(values []string) ([]netip.Prefix, error) {
+	var nets []netip.Prefix
+	for _, value := range values {
+		for _, entry := range strings.Split(value, ",") {
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				continue
+			}
+			prefix, err := parseNet(entry)
+			if err != nil {
+				return nil, fmt.Errorf("Expected 'allow' to list CIDR ranges or addresses, got %q", entry)
+			}
+			nets = append(nets, prefix)
+		}
+	}
+	if len(nets) > maxAllowedNets {
+		return nil, fmt.Errorf("Expected at most %d 'allow' entries", maxAllowedNets)
+	}
+	return nets, nil
+}
+
+func parseNet(entry string) (netip.Prefix, error) {
+	if strings.Contains(entry, "/") {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+			return netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96).Masked(), nil
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(entry)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// allowedFrom reports whether clientIP may open a link restricted to nets.
+// A link without networks is open to everybody; an address that does not
+// parse is refused by a restricted link.
+func allowedFrom(nets []netip.Prefix, clientIP string) bool {
+	if len(nets) == 0 {
+		return true
+	}
+	addr, err := netip.ParseAddr(clientIP)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range nets {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	minAliasLength = 3
+	maxAliasLength = 64
+	aliasCharset   = "abcdefghijklmnopqrstuvwxyz0123456789-_"
+)
+
+// ReservedAliases cannot be chosen as aliases: they are routes of the
+// server or could be mistaken for them.
+var ReservedAliases = []string{
+	"api", "create", "new", "s", "static", "metrics",
+	"admin", "health", "healthz", "login", "logout", "robots.txt", "favicon.ico",
+}
+
+// ErrAliasTaken is returned by createLink when the chosen alias is
+// already a key of another link.
+var ErrAliasTaken = errors.New("alias is already taken")
+
+// validateAlias checks a chosen alias: 3 to 64 lowercase letters, digits,
+// '-' or '_', starting with a letter or digit, and not reserved.
+func validateAlias(alias string) error {
+	if len(alias) < minAliasLength || len(alias) > maxAliasLength {
+		return fmt.Errorf("Expected 'alias' to be %d to %d characters long", minAliasLength, maxAliasLength)
+	}
+	if strings.Trim(alias, aliasCharset) != "" {
+		return errors.New("Expected 'alias' to contain only lowercase letters, digits, '-' and '_'")
+	}
+	if alias[0] == '-' || alias[0] == '_' {
+		return errors.New("Expected 'alias' to start with a letter or digit")
+	}
+	for _, reserved := range ReservedAliases {
+		if alias == reserved {
+			return fmt.Errorf("Alias %q is reserved", alias)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"secretlinks/metrics"
+	"secretlinks/storage"
+	"secretlinks/webhooks"
+	"strings"
+	"time"
+)
+
+// RevokeTokenHeader carries the token returned by /create to RevokeHandler.
+const RevokeTokenHeader = "X-Revoke-Token"
+
+// Link states reported by StatusHandler.
+const (
+	StatePending = "pending"
+	StateActive  = "active"
+	StateExpired = "expired"
+)
+
+// StatusResponse describes a link without using up a view.
+type StatusResponse struct {
+	Key            string     `json:"key"`
+	State          string     `json:"state"`
+	Views          int        `json:"views"`
+	MaxViews       int        `json:"max_views"`
+	RemainingViews int        `json:"remaining_views"`
+	NotBefore      *time.Time `json:"not_before,omitempty"`
+	ExpiresAt      time.Time  `json:"expires_at"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, message string, status int) {
+	writeJSON(w, status, errorResponse{Error: message})
+}
+
+// wantsJSON reports whether the client asked for a JSON answer.
+func wantsJSON(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		if mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept)); err == nil && mediaType == "application/json" {
+			return true
+		}
+	}
+	return false
+}
+
+func newRevokeToken() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// notBefore returns the activation time of link for JSON answers, nil if
+// the link was available at once.
+func notBefore(link storage.Link) *time.Time {
+	if link.NotBefore.IsZero() {
+		return nil
+	}
+	return &link.NotBefore
+}
+
+// linkState tells whether link is pending, active or expired at now.
+func linkState(link storage.Link, now time.Time) string {
+	switch {
+	case link.Views >= link.MaxViews || now.After(link.ExpiresAt):
+		return StateExpired
+	case now.Before(link.NotBefore):
+		return StatePending
+	}
+	return StateActive
+}
+
+func hashRevokeToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// StatusHandler reports the state of the link named by the {key} path
+// value. Anyone holding the link may ask; no view is used up.
+func StatusHandler(s storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.PathValue("key")
+		ctx, span := tracer.Start(r.Context(), "StatusHandler")
+		defer span.End()
+		span.SetAttributes(keyAttribute(key))
+
+		link, exists := traceStorage(ctx, s).Get(key)
+		if !exists {
+			writeError(w, "link not found", http.StatusNotFound)
+			return
+		}
+
+		status := StatusResponse{
+			Key:            key,
+			State:          linkState(link, time.Now()),
+			Views:          link.Views,
+			MaxViews:       link.MaxViews,
+			RemainingViews: max(link.MaxViews-link.Views, 0),
+			NotBefore:      notBefore(link),
+			ExpiresAt:      link.ExpiresAt,
+		}
+		writeJSON(w, http.StatusOK, status)
+	}
+}
+
+// RevokeHandler deletes the link named by the {key} path value before it
+// runs out. The caller must present the revoke token of that link.
+func RevokeHandler(s storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.PathValue("key")
+		ctx, span := tracer.Start(r.Context(), "RevokeHandler")
+		defer span.End()
+		span.SetAttributes(keyAttribute(key))
+		groups := s
+		s := traceStorage(ctx, s)
+
+		link, exists := s.Get(key)
+		if !exists {
+			writeError(w, "link not found", http.StatusNotFound)
+			return
+		}
+		token := r.Header.Get(RevokeTokenHeader)
+		if token == "" || link.RevokeHash == "" ||
+			subtle.ConstantTimeCompare([]byte(hashRevokeToken(token)), []byte(link.RevokeHash)) != 1 {
+			writeError(w, "invalid revoke token", http.StatusForbidden)
+			return
+		}
+
+		s.Delete(key)
+		updateMember(groups, key, link, func(m *storage.Member) { m.Revoked = true })
+		sendRevoked(ctx, key)
+		notifyCreator(key, link, webhooks.EventRevoked)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func sendRevoked(ctx context.Context, key string) {
+	metrics.SecretsRevoked.Inc()
+	SendEvent(ctx, KafkaStatsItem{
+		LinkKey: key,
+		NowTime: time.Now(),
+	}, Topics.RevokedLinks)
+}
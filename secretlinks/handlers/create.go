@@ -1,17 +1,35 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"math/rand"
 	"net/http"
+	"net/netip"
+	"secretlinks/metrics"
 	"secretlinks/middleware"
+	"secretlinks/notify"
+	"secretlinks/qr"
 	"secretlinks/storage"
+	"secretlinks/webhooks"
 	"strconv"
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 
+// Used when a /create request does not set expiration (minutes) or maxviews.
+var (
+	DefaultExpiration = 60
+	DefaultMaxViews   = 1
+)
+
+// maxActivationDelay bounds how far ahead not_before may lie.
+const maxActivationDelay = 366 * 24 * time.Hour
+
 func generateKey(length int) string {
 	b := make([]byte, length)
 	for i := range b {
@@ -22,55 +40,263 @@ func generateKey(length int) string {
 
 func CreateHandler(s storage.Storage) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := tracer.Start(r.Context(), "CreateHandler")
+		defer span.End()
+
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
-		if r.FormValue("secret") == "" {
-			http.Error(w, "Expected 'secret' value", http.StatusNotAcceptable)
+		req, err := parseCreateRequest(r)
+		if err != nil {
+			createError(w, r, err.Error(), http.StatusNotAcceptable)
+			return
+		}
+		if req.Alias != "" && !middleware.Authenticated(ctx) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			createError(w, r, "An API key is required to choose an alias", http.StatusUnauthorized)
+			return
+		}
+		// Otherwise anybody could have the server mail any address.
+		if req.NotifyEmail != "" && !middleware.Authenticated(ctx) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			createError(w, r, "An API key is required for email notifications", http.StatusUnauthorized)
+			return
+		}
+		if len(req.Recipients) > 0 {
+			writeGroup(ctx, w, r, s, req)
 			return
 		}
-		secret := middleware.EncryptText(r.FormValue("secret"))
-
-		expiration, err := strconv.Atoi(r.FormValue("expiration"))
 
-		if err != nil {
-			if r.FormValue("expiration") == "" {
-				expiration = 60
-			} else {
-				http.Error(w, "Expected int value", http.StatusNotAcceptable)
+		key, link, revokeToken, err := createLink(ctx, s, req)
+		if errors.Is(err, ErrAliasTaken) {
+			createError(w, r, fmt.Sprintf("Alias %q is already taken", req.Alias), http.StatusConflict)
+			return
+		}
+		if req.QR != "" {
+			// Written straight to the client; the image is neither logged
+			// nor kept.
+			image, err := qr.Encode(LinkURL(r, key), req.QR)
+			if err != nil {
+				// Nobody learns the key, so the link must not outlive
+				// the failed request.
+				s.Delete(key)
+				sendRevoked(ctx, key)
+				http.Error(w, "Cannot render QR code", http.StatusInternalServerError)
 				return
 			}
+			if link.WebhookSecret != "" {
+				w.Header().Set(WebhookSecretHeader, link.WebhookSecret)
+			}
+			w.Header().Set("Content-Type", qr.ContentType(req.QR))
+			w.Header().Set(RevokeTokenHeader, revokeToken)
+			w.Write(image)
+			return
 		}
+		if link.WebhookSecret != "" {
+			w.Header().Set(WebhookSecretHeader, link.WebhookSecret)
+		}
+		if wantsJSON(r) {
+			writeJSON(w, http.StatusOK, CreateResponse{
+				URL:           LinkURL(r, key),
+				Key:           key,
+				NotBefore:     notBefore(link),
+				ExpiresAt:     link.ExpiresAt,
+				MaxViews:      link.MaxViews,
+				RevokeToken:   revokeToken,
+				WebhookSecret: link.WebhookSecret,
+			})
+			return
+		}
+		fmt.Fprint(w, LinkURL(r, key))
+	}
+}
 
-		maxViews, err := strconv.Atoi(r.FormValue("maxviews"))
+// createError answers a failed /create as JSON to clients that accept it
+// and as plain text otherwise.
+func createError(w http.ResponseWriter, r *http.Request, message string, status int) {
+	if wantsJSON(r) {
+		writeError(w, message, status)
+		return
+	}
+	http.Error(w, message, status)
+}
+
+// CreateResponse is the answer of /create to clients that accept JSON.
+// RevokeToken is shown only here and is needed to revoke the link.
+type CreateResponse struct {
+	URL         string     `json:"url"`
+	Key         string     `json:"key"`
+	NotBefore   *time.Time `json:"not_before,omitempty"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	MaxViews    int        `json:"max_views"`
+	RevokeToken string     `json:"revoke_token"`
+	// WebhookSecret signs the webhooks of the link; set when one was given.
+	WebhookSecret string `json:"webhook_secret,omitempty"`
+}
 
+// createRequest holds the form values of a new link.
+type createRequest struct {
+	Secret     string
+	Expiration int // minutes, counted from NotBefore when it is set
+	MaxViews   int
+	// NotBefore delays the link's availability.
+	NotBefore time.Time
+	// QR asks for the link as a QR code image: "png" or "svg".
+	QR string
+	// Alias is the key chosen by the caller instead of a random one.
+	Alias string
+	// AllowedNets restrict the link to these client networks.
+	AllowedNets []netip.Prefix
+	// Webhook is the callback URL told about the link's events.
+	Webhook string
+	// NotifyEmail is the creator's address for notification emails.
+	NotifyEmail string
+	// Recipients ask for a group of links, one per recipient.
+	Recipients []string
+}
+
+func parseCreateRequest(r *http.Request) (createRequest, error) {
+	req := createRequest{Secret: r.FormValue("secret")}
+	if req.Secret == "" {
+		return req, errors.New("Expected 'secret' value")
+	}
+
+	var err error
+	req.Expiration, err = strconv.Atoi(r.FormValue("expiration"))
+	if err != nil {
+		if r.FormValue("expiration") != "" {
+			return req, errors.New("Expected int value")
+		}
+		req.Expiration = DefaultExpiration
+	}
+
+	req.MaxViews, err = strconv.Atoi(r.FormValue("maxviews"))
+	if err != nil {
+		if r.FormValue("maxviews") != "" {
+			return req, errors.New("Expected int value")
+		}
+		req.MaxViews = DefaultMaxViews
+	}
+
+	if value := r.FormValue("not_before"); value != "" {
+		req.NotBefore, err = time.Parse(time.RFC3339, value)
 		if err != nil {
-			if r.FormValue("maxviews") == "" {
-				maxViews = 1
-			} else {
-				http.Error(w, "Expected int value", http.StatusNotAcceptable)
-				return
-			}
+			return req, errors.New("Expected 'not_before' as RFC 3339 time, e.g. 2025-07-21T09:00:00Z")
+		}
+		if req.NotBefore.After(time.Now().Add(maxActivationDelay)) {
+			return req, errors.New("Expected 'not_before' within a year")
 		}
+	}
 
-		var resultKey string
+	switch req.QR = r.FormValue("qr"); req.QR {
+	case "", qr.PNG, qr.SVG:
+	default:
+		return req, errors.New("Expected 'qr' to be png or svg")
+	}
 
-		for keyIsUnique := false; !keyIsUnique; {
-			key := generateKey(8)
-			expiresAt := time.Now().Add(time.Duration(expiration) * time.Minute) // Пример: фиксированное время
-			link := storage.Link{
-				Secret:    secret,
-				ExpiresAt: expiresAt,
-				MaxViews:  maxViews,
-			}
+	if req.Alias = r.FormValue("alias"); req.Alias != "" {
+		if err := validateAlias(req.Alias); err != nil {
+			return req, err
+		}
+	}
+
+	req.AllowedNets, err = parseAllowedNets(r.Form["allow"])
+	if err != nil {
+		return req, err
+	}
+
+	if req.Webhook = r.FormValue("webhook"); req.Webhook != "" {
+		if Webhooks == nil {
+			return req, errors.New("Webhooks are not enabled on this server")
+		}
+		if err := webhooks.ValidateURL(req.Webhook); err != nil {
+			return req, fmt.Errorf("Expected 'webhook': %w", err)
+		}
+	}
+
+	if address := r.FormValue("notify"); address != "" {
+		if Notifications == nil {
+			return req, errors.New("Email notifications are not enabled on this server")
+		}
+		req.NotifyEmail, err = notify.ParseAddress(address)
+		if err != nil {
+			return req, errors.New("Expected 'notify' to be an email address")
+		}
+	}
 
-			keyIsUnique = s.Create(key, link, true)
-			resultKey = key
+	req.Recipients, err = parseRecipients(r.Form["recipient"])
+	if err != nil {
+		return req, err
+	}
+	if len(req.Recipients) > 0 && (req.Alias != "" || req.QR != "") {
+		return req, errors.New("Expected 'recipient' without 'alias' or 'qr'")
+	}
+	return req, nil
+}
+
+// createLink stores the encrypted secret under the requested alias or a
+// new unique key and reports the creation. It returns the key, the stored
+// link and the token that revokes it, or ErrAliasTaken.
+func createLink(ctx context.Context, s storage.Storage, req createRequest) (string, storage.Link, string, error) {
+	secret := encrypt(ctx, req.Secret)
+	revokeToken := newRevokeToken()
+	var webhookSecret string
+	if req.Webhook != "" {
+		webhookSecret = newRevokeToken()
+	}
+	key, link, err := storeLink(ctx, s, req, secret, revokeToken, webhookSecret, "")
+	return key, link, revokeToken, err
+}
+
+// storeLink stores the already encrypted secret as a link of req in the
+// given group, "" for none, and reports the creation.
+func storeLink(ctx context.Context, s storage.Storage, req createRequest, secret, revokeToken, webhookSecret, group string) (string, storage.Link, error) {
+	span := trace.SpanFromContext(ctx)
+	s = traceStorage(ctx, s)
+
+	var resultKey string
+	var resultLink storage.Link
+
+	for keyIsUnique := false; !keyIsUnique; {
+		key := req.Alias
+		if key == "" {
+			key = generateKey(8)
+		}
+		start := time.Now()
+		if req.NotBefore.After(start) {
+			start = req.NotBefore
+		}
+		expiresAt := start.Add(time.Duration(req.Expiration) * time.Minute)
+		link := storage.Link{
+			Secret:      secret,
+			ExpiresAt:   expiresAt,
+			MaxViews:    req.MaxViews,
+			RevokeHash:  hashRevokeToken(revokeToken),
+			AllowedNets: req.AllowedNets,
+			NotBefore:   req.NotBefore,
+
+			WebhookURL:    req.Webhook,
+			WebhookSecret: webhookSecret,
+			NotifyEmail:   req.NotifyEmail,
+			Group:         group,
 		}
-		SendStats(resultKey, "newlinks")
 
-		fmt.Fprintf(w, "http://%s/%s", r.Host, resultKey)
+		keyIsUnique = s.Create(key, link, true)
+		if !keyIsUnique && req.Alias != "" {
+			return "", storage.Link{}, ErrAliasTaken
+		}
+		resultKey = key
+		resultLink = link
 	}
+	span.SetAttributes(keyAttribute(resultKey))
+	metrics.SecretsCreated.Inc()
+	SendEvent(ctx, KafkaStatsItem{
+		LinkKey:   resultKey,
+		NowTime:   time.Now(),
+		ExpiresAt: &resultLink.ExpiresAt,
+		MaxViews:  resultLink.MaxViews,
+	}, Topics.NewLinks)
+	return resultKey, resultLink, nil
 }
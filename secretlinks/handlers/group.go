@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"secretlinks/storage"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Headers of a group created by a plain-text /create; JSON answers have
+// them in group and group_token.
+const (
+	GroupHeader      = "X-Group"
+	GroupTokenHeader = "X-Group-Token"
+)
+
+// Limits of the "recipient" form values.
+const (
+	maxRecipients      = 50
+	maxRecipientLength = 100
+)
+
+// StateRevoked is reported by GroupStatusHandler for a member whose link
+// was revoked.
+const StateRevoked = "revoked"
+
+// GroupStorage is implemented by storages that keep groups of links
+// created for several recipients of one secret.
+type GroupStorage interface {
+	storage.Storage
+	CreateGroup(id string, group storage.Group) bool
+	GetGroup(id string) (storage.Group, bool)
+	UpdateGroup(id string, group storage.Group)
+	UpdateMember(id, key string, update func(*storage.Member)) bool
+}
+
+// GroupResponse is the answer of /create for several recipients to
+// clients that accept JSON. GroupToken is shown only here and is needed
+// to read the group's status.
+type GroupResponse struct {
+	Group      string      `json:"group"`
+	GroupToken string      `json:"group_token"`
+	NotBefore  *time.Time  `json:"not_before,omitempty"`
+	ExpiresAt  time.Time   `json:"expires_at"`
+	MaxViews   int         `json:"max_views"`
+	Links      []GroupLink `json:"links"`
+	// WebhookSecret signs the webhooks of every link of the group.
+	WebhookSecret string `json:"webhook_secret,omitempty"`
+}
+
+// GroupLink is the link of one recipient; RevokeToken revokes just it.
+type GroupLink struct {
+	Recipient   string `json:"recipient"`
+	URL         string `json:"url"`
+	Key         string `json:"key"`
+	RevokeToken string `json:"revoke_token"`
+}
+
+// GroupStatusResponse tells the sender who has opened their link.
+type GroupStatusResponse struct {
+	Group     string         `json:"group"`
+	ExpiresAt time.Time      `json:"expires_at"`
+	Members   []MemberStatus `json:"members"`
+}
+
+// MemberStatus describes the link of one recipient. OpenedAt is the time
+// of its first view.
+type MemberStatus struct {
+	Recipient string     `json:"recipient"`
+	Key       string     `json:"key"`
+	State     string     `json:"state"`
+	Views     int        `json:"views"`
+	MaxViews  int        `json:"max_views"`
+	OpenedAt  *time.Time `json:"opened_at,omitempty"`
+}
+
+// parseRecipients reads the "recipient" form values: names or addresses
+// the sender tells the links apart by, comma-separated or repeated.
+func parseRecipients(values []string) ([]string, error) {
+	var recipients []string
+	seen := make(map[string]bool)
+	for _, value := range values {
+		for _, recipient := range strings.Split(value, ",") {
+			recipient = strings.TrimSpace(recipient)
+			if recipient == "" {
+				continue
+			}
+			if len(recipient) > maxRecipientLength {
+				return nil, fmt.Errorf("Expected 'recipient' of at most %d characters", maxRecipientLength)
+			}
+			// The plain-text answer is one "recipient\turl" line each.
+			if strings.ContainsFunc(recipient, unicode.IsControl) {
+				return nil, fmt.Errorf("Recipient %q contains control characters", recipient)
+			}
+			if seen[recipient] {
+				return nil, fmt.Errorf("Recipient %q is listed twice", recipient)
+			}
+			seen[recipient] = true
+			recipients = append(recipients, recipient)
+		}
+	}
+	if len(recipients) > maxRecipients {
+		return nil, fmt.Errorf("Expected at most %d 'recipient' entries", maxRecipients)
+	}
+	return recipients, nil
+}
+
+// createGroup stores one link per recipient of req, all holding the same
+// encrypted secret but counting views and revoked on their own, and the
+// group that ties them together. It returns the group's id and token.
+func createGroup(ctx context.Context, s GroupStorage, req createRequest) (string, string, GroupResponse) {
+	secret := encrypt(ctx, req.Secret)
+	groupToken := newRevokeToken()
+	var webhookSecret string
+	if req.Webhook != "" {
+		webhookSecret = newRevokeToken()
+	}
+
+	// The id is reserved first, as every link records it.
+	group := storage.Group{TokenHash: hashRevokeToken(groupToken), MaxViews: req.MaxViews, NotBefore: req.NotBefore}
+	id := generateKey(12)
+	for !s.CreateGroup(id, group) {
+		id = generateKey(12)
+	}
+
+	resp := GroupResponse{Group: id, GroupToken: groupToken, WebhookSecret: webhookSecret}
+	for _, recipient := range req.Recipients {
+		revokeToken := newRevokeToken()
+		// Without an alias storeLink cannot fail.
+		key, link, _ := storeLink(ctx, s, req, secret, revokeToken, webhookSecret, id)
+		group.ExpiresAt = link.ExpiresAt
+		group.Members = append(group.Members, storage.Member{Recipient: recipient, Key: key})
+		resp.Links = append(resp.Links, GroupLink{Recipient: recipient, Key: key, RevokeToken: revokeToken})
+		resp.NotBefore = notBefore(link)
+		resp.ExpiresAt = link.ExpiresAt
+		resp.MaxViews = link.MaxViews
+	}
+	s.UpdateGroup(id, group)
+	return id, groupToken, resp
+}
+
+// writeGroup answers a /create for several recipients: as JSON to clients
+// that accept it, otherwise one "recipient<TAB>url" line per link with the
+// group's id and token in headers.
+func writeGroup(ctx context.Context, w http.ResponseWriter, r *http.Request, s storage.Storage, req createRequest) {
+	groups, ok := s.(GroupStorage)
+	if !ok {
+		createError(w, r, "Links for several recipients are not supported by this storage", http.StatusNotImplemented)
+		return
+	}
+	id, token, resp := createGroup(ctx, groups, req)
+	for i := range resp.Links {
+		resp.Links[i].URL = LinkURL(r, resp.Links[i].Key)
+	}
+	if wantsJSON(r) {
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+	w.Header().Set(GroupHeader, id)
+	w.Header().Set(GroupTokenHeader, token)
+	if resp.WebhookSecret != "" {
+		w.Header().Set(WebhookSecretHeader, resp.WebhookSecret)
+	}
+	for _, link := range resp.Links {
+		fmt.Fprintf(w, "%s\t%s\n", link.Recipient, link.URL)
+	}
+}
+
+// GroupStatusHandler reports who has opened their link of the group named
+// by the {id} path value. The caller must present the group token.
+func GroupStatusHandler(s GroupStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		ctx, span := tracer.Start(r.Context(), "GroupStatusHandler")
+		defer span.End()
+
+		group, exists := s.GetGroup(id)
+		if !exists {
+			writeError(w, "group not found", http.StatusNotFound)
+			return
+		}
+		token := r.Header.Get(GroupTokenHeader)
+		if token == "" || subtle.ConstantTimeCompare([]byte(hashRevokeToken(token)), []byte(group.TokenHash)) != 1 {
+			writeError(w, "invalid group token", http.StatusForbidden)
+			return
+		}
+
+		links := traceStorage(ctx, s)
+		status := GroupStatusResponse{Group: id, ExpiresAt: group.ExpiresAt, Members: []MemberStatus{}}
+		now := time.Now()
+		for _, member := range group.Members {
+			ms := MemberStatus{
+				Recipient: member.Recipient,
+				Key:       member.Key,
+				State:     StateExpired,
+				Views:     member.Views,
+				MaxViews:  group.MaxViews,
+			}
+			if !member.OpenedAt.IsZero() {
+				ms.OpenedAt = &member.OpenedAt
+			}
+			// A key reused after the link is gone belongs to somebody else.
+			if link, exists := links.Get(member.Key); exists && link.Group == id {
+				ms.State = linkState(link, now)
+			} else if member.Revoked {
+				ms.State = StateRevoked
+			}
+			status.Members = append(status.Members, ms)
+		}
+		writeJSON(w, http.StatusOK, status)
+	}
+}
+
+// updateMember applies update to the group member holding the link key,
+// if s keeps groups and the link is in one.
+func updateMember(s storage.Storage, key string, link storage.Link, update func(*storage.Member)) {
+	if groups, ok := s.(GroupStorage); ok && link.Group != "" {
+		groups.UpdateMember(link.Group, key, update)
+	}
+}
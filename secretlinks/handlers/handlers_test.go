@@ -1,17 +1,38 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"secretlinks/events"
+	"secretlinks/metrics"
 	"secretlinks/middleware"
+	"secretlinks/notify"
+	"secretlinks/qr"
 	"secretlinks/storage"
+	"secretlinks/webhooks"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/segmentio/kafka-go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
 type MockStorage struct {
@@ -279,3 +300,917 @@ func TestRedirectHandler_InvalidMaxViews(t *testing.T) {
 	mockStorage.AssertExpectations(t)
 	assert.Contains(t, w.Body.String(), "Link expired")
 }
+
+type MockWriter struct {
+	mock.Mock
+}
+
+func (m *MockWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	args := m.Called(ctx, msgs)
+	return args.Error(0)
+}
+
+func (m *MockWriter) Close() error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+func TestPublisher_CloseFlushesQueue(t *testing.T) {
+	writer := new(MockWriter)
+	writer.On("WriteMessages", mock.Anything, mock.Anything).Return(nil).Twice()
+	writer.On("Close").Return(nil).Once()
+
+	publisher := NewPublisherWithWriter(writer, 10)
+	publisher.Publish(context.Background(), "newlinks", KafkaStatsItem{LinkKey: "first", NowTime: time.Now()})
+	publisher.Publish(context.Background(), "updatelinks", KafkaStatsItem{LinkKey: "first", NowTime: time.Now()})
+
+	assert.NoError(t, publisher.Close(context.Background()))
+	writer.AssertExpectations(t)
+
+	msgs := writer.Calls[0].Arguments[1].([]kafka.Message)
+	assert.Equal(t, "newlinks", msgs[0].Topic)
+	assert.Equal(t, "first", string(msgs[0].Key))
+}
+
+func TestPublisher_DropsWhenQueueFull(t *testing.T) {
+	release := make(chan struct{})
+	writer := new(MockWriter)
+	writer.On("WriteMessages", mock.Anything, mock.Anything).Run(func(mock.Arguments) { <-release }).Return(nil)
+	writer.On("Close").Return(nil)
+
+	before := testutil.ToFloat64(metrics.EventPublishFailures.WithLabelValues("newlinks", "queue_full"))
+	publisher := NewPublisherWithWriter(writer, 1)
+	for i := 0; i < 5; i++ {
+		publisher.Publish(context.Background(), "newlinks", KafkaStatsItem{LinkKey: "key", NowTime: time.Now()})
+	}
+	close(release)
+	assert.NoError(t, publisher.Close(context.Background()))
+
+	dropped := testutil.ToFloat64(metrics.EventPublishFailures.WithLabelValues("newlinks", "queue_full")) - before
+	assert.GreaterOrEqual(t, dropped, 3.0)
+}
+
+func TestCreateHandler_CountsCreatedSecrets(t *testing.T) {
+	mockStorage := new(MockStorage)
+	mockStorage.On("Create", mock.Anything, mock.Anything, true).Return(true)
+
+	before := testutil.ToFloat64(metrics.SecretsCreated)
+	form := url.Values{"secret": []string{"counted"}}
+	req := httptest.NewRequest("POST", "/create", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	CreateHandler(mockStorage)(w, req)
+
+	assert.Equal(t, before+1, testutil.ToFloat64(metrics.SecretsCreated))
+}
+
+func TestCreateHandler_TracesAndPropagatesContext(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	writer := new(MockWriter)
+	writer.On("WriteMessages", mock.Anything, mock.Anything).Return(nil)
+	writer.On("Close").Return(nil)
+	Events = NewPublisherWithWriter(writer, 10)
+	defer func() { Events = nil }()
+
+	mockStorage := new(MockStorage)
+	mockStorage.On("Create", mock.Anything, mock.Anything, true).Return(true)
+
+	form := url.Values{"secret": []string{"traced"}}
+	req := httptest.NewRequest("POST", "/create", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	CreateHandler(mockStorage)(w, req)
+	assert.NoError(t, Events.Close(context.Background()))
+
+	names := map[string]bool{}
+	var handlerSpan sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		names[span.Name()] = true
+		if span.Name() == "CreateHandler" {
+			handlerSpan = span
+		}
+	}
+	assert.True(t, names["CreateHandler"])
+	assert.True(t, names["encrypt"])
+	assert.True(t, names["storage.Create"])
+	assert.True(t, names["publish newlinks"])
+
+	msgs := writer.Calls[0].Arguments[1].([]kafka.Message)
+	var traceparent string
+	for _, h := range msgs[0].Headers {
+		if h.Key == "traceparent" {
+			traceparent = string(h.Value)
+		}
+	}
+	assert.Contains(t, traceparent, handlerSpan.SpanContext().TraceID().String())
+}
+
+func TestSweep_ReportsExpiredLinks(t *testing.T) {
+	writer := new(MockWriter)
+	writer.On("WriteMessages", mock.Anything, mock.Anything).Return(nil)
+	writer.On("Close").Return(nil)
+	Events = NewPublisherWithWriter(writer, 10)
+	defer func() { Events = nil }()
+
+	now := time.Now()
+	memoryStorage := storage.NewMemoryStorage()
+	memoryStorage.Create("old", storage.Link{ExpiresAt: now.Add(-time.Minute), MaxViews: 1}, true)
+	memoryStorage.Create("fresh", storage.Link{ExpiresAt: now.Add(time.Minute), MaxViews: 1}, true)
+
+	before := testutil.ToFloat64(metrics.SecretsExpired.WithLabelValues(events.ReasonTime))
+	assert.Equal(t, 1, Sweep(context.Background(), memoryStorage, now))
+	assert.NoError(t, Events.Close(context.Background()))
+
+	assert.Equal(t, 1, memoryStorage.Len())
+	assert.Equal(t, before+1, testutil.ToFloat64(metrics.SecretsExpired.WithLabelValues(events.ReasonTime)))
+	msgs := writer.Calls[0].Arguments[1].([]kafka.Message)
+	assert.Equal(t, Topics.ExpiredLinks, msgs[0].Topic)
+	event, err := events.Decode(msgs[0])
+	assert.NoError(t, err)
+	assert.Equal(t, "old", event.LinkKey)
+	assert.Equal(t, events.ReasonTime, event.Reason)
+}
+
+func TestSweep_ReportsExhaustedLinksByViews(t *testing.T) {
+	writer := new(MockWriter)
+	writer.On("WriteMessages", mock.Anything, mock.Anything).Return(nil)
+	writer.On("Close").Return(nil)
+	Events = NewPublisherWithWriter(writer, 10)
+	defer func() { Events = nil }()
+
+	now := time.Now()
+	memoryStorage := storage.NewMemoryStorage()
+	memoryStorage.Create("read", storage.Link{ExpiresAt: now.Add(-time.Minute), MaxViews: 2, Views: 2}, true)
+
+	byTime := testutil.ToFloat64(metrics.SecretsExpired.WithLabelValues(events.ReasonTime))
+	byViews := testutil.ToFloat64(metrics.SecretsExpired.WithLabelValues(events.ReasonViews))
+	assert.Equal(t, 1, Sweep(context.Background(), memoryStorage, now))
+	assert.NoError(t, Events.Close(context.Background()))
+
+	assert.Equal(t, byTime, testutil.ToFloat64(metrics.SecretsExpired.WithLabelValues(events.ReasonTime)))
+	assert.Equal(t, byViews+1, testutil.ToFloat64(metrics.SecretsExpired.WithLabelValues(events.ReasonViews)))
+	msgs := writer.Calls[0].Arguments[1].([]kafka.Message)
+	event, err := events.Decode(msgs[0])
+	assert.NoError(t, err)
+	assert.Equal(t, "read", event.LinkKey)
+	assert.Equal(t, events.ReasonViews, event.Reason)
+}
+
+func TestCreateHandler_LinkSchemeFromTrustedProxy(t *testing.T) {
+	mockStorage := new(MockStorage)
+	mockStorage.On("Create", mock.Anything, mock.Anything, true).Return(true)
+	proxies, err := middleware.ParseTrustedProxies([]string{"10.0.0.0/8"})
+	assert.NoError(t, err)
+	handler := middleware.ProxyMiddleware(proxies, CreateHandler(mockStorage))
+
+	form := url.Values{"secret": []string{"behind proxy"}}
+	req := httptest.NewRequest("POST", "/create", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Forwarded-Proto", "https")
+	req.RemoteAddr = "10.0.0.2:5555"
+	req.Host = "links.example.com"
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, strings.HasPrefix(w.Body.String(), "https://links.example.com/"), w.Body.String())
+}
+
+func TestCreateHandler_LinkFromBaseURL(t *testing.T) {
+	BaseURL, _ = url.Parse("https://example.com/secrets/")
+	defer func() { BaseURL = nil }()
+	mockStorage := new(MockStorage)
+	mockStorage.On("Create", mock.Anything, mock.Anything, true).Return(true)
+
+	form := url.Values{"secret": []string{"canonical"}}
+	req := httptest.NewRequest("POST", "/secrets/create", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Host = "attacker.example"
+	w := httptest.NewRecorder()
+	CreateHandler(mockStorage)(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Regexp(t, `^https://example\.com/secrets/[a-zA-Z0-9]{8}$`, w.Body.String())
+}
+
+func TestRedirectHandler_UnderBasePath(t *testing.T) {
+	BaseURL, _ = url.Parse("https://example.com/secrets")
+	defer func() { BaseURL = nil }()
+	assert.Equal(t, "/secrets/", BasePath())
+
+	mockStorage := new(MockStorage)
+	mockStorage.On("Get", "valid_key").Return(storage.Link{
+		Secret:    middleware.EncryptText("prefixed"),
+		ExpiresAt: time.Now().Add(time.Hour),
+		MaxViews:  1,
+	}, true).Once()
+	mockStorage.On("Update", "valid_key", mock.AnythingOfType("storage.Link")).Return().Once()
+
+	req := httptest.NewRequest("GET", "/secrets/valid_key", nil)
+	w := httptest.NewRecorder()
+	RedirectHandler(mockStorage)(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "prefixed", w.Body.String())
+	mockStorage.AssertExpectations(t)
+}
+
+func newUIMux(s storage.Storage) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("GET /{$}", UIFormHandler())
+	mux.Handle("POST /new", UICreateHandler(s))
+	mux.Handle("/s/{key}", UIRevealHandler(s))
+	return mux
+}
+
+func TestUI_CreateAndReveal(t *testing.T) {
+	memoryStorage := storage.NewMemoryStorage()
+	mux := newUIMux(memoryStorage)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `<form method="post" action="/new">`)
+	assert.NotContains(t, w.Body.String(), "https://", "no external assets")
+
+	form := url.Values{"secret": []string{"<b>pa$$</b>"}, "expiration": []string{"60"}, "maxviews": []string{"1"}}
+	req := httptest.NewRequest("POST", "/new", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Host = "links.example.com"
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Regexp(t, `value="http://links\.example\.com/s/[a-zA-Z0-9]{8}"`, w.Body.String())
+	assert.NotContains(t, w.Body.String(), "pa$$", "the result page does not repeat the secret")
+	assert.Equal(t, 1, memoryStorage.Len())
+
+	link := regexp.MustCompile(`/s/[a-zA-Z0-9]{8}`).FindString(w.Body.String())
+
+	// Opening the link only asks for confirmation.
+	for i := 0; i < 2; i++ {
+		w = httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", link, nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "Reveal secret")
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("POST", link, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "&lt;b&gt;pa$$&lt;/b&gt;")
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", link, nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("POST", link, nil))
+	assert.Equal(t, http.StatusGone, w.Code)
+}
+
+func TestUI_CreateRejectsBadInput(t *testing.T) {
+	memoryStorage := storage.NewMemoryStorage()
+	mux := newUIMux(memoryStorage)
+
+	for _, form := range []url.Values{
+		{"secret": []string{""}},
+		{"secret": []string{"kept"}, "maxviews": []string{"0"}},
+		{"secret": []string{"kept"}, "expiration": []string{"soon"}},
+		{"secret": []string{"kept"}, "recipient": []string{"alice,bob"}},
+	} {
+		req := httptest.NewRequest("POST", "/new", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), `class="error"`)
+		assert.Contains(t, w.Body.String(), form.Get("secret"))
+	}
+	assert.Equal(t, 0, memoryStorage.Len())
+}
+
+func TestRevokeHandler_ReportsRevocation(t *testing.T) {
+	writer := new(MockWriter)
+	writer.On("WriteMessages", mock.Anything, mock.Anything).Return(nil)
+	writer.On("Close").Return(nil)
+	Events = NewPublisherWithWriter(writer, 10)
+	defer func() { Events = nil }()
+
+	memoryStorage := storage.NewMemoryStorage()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/create", CreateHandler(memoryStorage))
+	mux.HandleFunc("DELETE /api/links/{key}", RevokeHandler(memoryStorage))
+
+	form := url.Values{"secret": []string{"revoke me"}}
+	req := httptest.NewRequest("POST", "/create", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	var created CreateResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	link, _ := memoryStorage.Get(created.Key)
+	assert.NotContains(t, link.RevokeHash, created.RevokeToken, "only the hash is stored")
+
+	before := testutil.ToFloat64(metrics.SecretsRevoked)
+	req = httptest.NewRequest("DELETE", "/api/links/"+created.Key, nil)
+	req.Header.Set(RevokeTokenHeader, created.RevokeToken)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.NoError(t, Events.Close(context.Background()))
+
+	assert.Equal(t, before+1, testutil.ToFloat64(metrics.SecretsRevoked))
+	var topics []string
+	for _, call := range writer.Calls {
+		if call.Method == "WriteMessages" {
+			topics = append(topics, call.Arguments[1].([]kafka.Message)[0].Topic)
+		}
+	}
+	assert.Equal(t, []string{Topics.NewLinks, Topics.RevokedLinks}, topics)
+}
+
+func TestCreateHandler_QRCode(t *testing.T) {
+	for _, format := range []string{"png", "svg"} {
+		memoryStorage := storage.NewMemoryStorage()
+		form := url.Values{"secret": []string{"wifi password"}, "qr": []string{format}}
+		req := httptest.NewRequest("POST", "/create", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		CreateHandler(memoryStorage)(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, qr.ContentType(format), w.Header().Get("Content-Type"))
+		assert.NotEmpty(t, w.Header().Get(RevokeTokenHeader))
+		assert.Equal(t, 1, memoryStorage.Len())
+	}
+	png := httptest.NewRecorder()
+	form := url.Values{"secret": []string{"x"}, "qr": []string{"png"}}
+	req := httptest.NewRequest("POST", "/create", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	CreateHandler(storage.NewMemoryStorage())(png, req)
+	assert.True(t, bytes.HasPrefix(png.Body.Bytes(), []byte("\x89PNG")))
+}
+
+func TestCreateHandler_QRCodeFailureRemovesLink(t *testing.T) {
+	// Too long to fit in any QR code.
+	BaseURL, _ = url.Parse("https://example.com/" + strings.Repeat("a", 3000) + "/")
+	defer func() { BaseURL = nil }()
+
+	memoryStorage := storage.NewMemoryStorage()
+	form := url.Values{"secret": []string{"x"}, "qr": []string{"png"}}
+	req := httptest.NewRequest("POST", "/create", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	CreateHandler(memoryStorage)(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Empty(t, w.Header().Get(RevokeTokenHeader))
+	assert.Equal(t, 0, memoryStorage.Len(), "a link nobody received must not stay")
+}
+
+func TestCreateHandler_QRCodeUnknownFormat(t *testing.T) {
+	memoryStorage := storage.NewMemoryStorage()
+	form := url.Values{"secret": []string{"x"}, "qr": []string{"gif"}}
+	req := httptest.NewRequest("POST", "/create", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	CreateHandler(memoryStorage)(w, req)
+
+	assert.Equal(t, http.StatusNotAcceptable, w.Code)
+	assert.Equal(t, 0, memoryStorage.Len(), "no link is created for a bad request")
+}
+
+func TestUI_ResultShowsQRCode(t *testing.T) {
+	mux := newUIMux(storage.NewMemoryStorage())
+	form := url.Values{"secret": []string{"scan me"}}
+	req := httptest.NewRequest("POST", "/new", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `<figure class="qr"><svg xmlns="http://www.w3.org/2000/svg"`)
+}
+
+func postAlias(handler http.Handler, alias, apiKey string) *httptest.ResponseRecorder {
+	form := url.Values{"secret": []string{"onboarding"}, "alias": []string{alias}}
+	req := httptest.NewRequest("POST", "/create", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestCreateHandler_Alias(t *testing.T) {
+	memoryStorage := storage.NewMemoryStorage()
+	handler := middleware.AuthMiddleware(middleware.NewAPIKeys([]string{"sk-test"}), CreateHandler(memoryStorage))
+
+	w := postAlias(handler, "team-wifi", "sk-test")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp CreateResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "team-wifi", resp.Key)
+	assert.Equal(t, "http://example.com/team-wifi", resp.URL)
+	_, ok := memoryStorage.Get("team-wifi")
+	assert.True(t, ok)
+
+	w = postAlias(handler, "team-wifi", "sk-test")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.JSONEq(t, `{"error":"Alias \"team-wifi\" is already taken"}`, w.Body.String())
+	assert.Equal(t, 1, memoryStorage.Len())
+}
+
+func TestCreateHandler_AliasRequiresAPIKey(t *testing.T) {
+	memoryStorage := storage.NewMemoryStorage()
+	handler := middleware.AuthMiddleware(middleware.NewAPIKeys([]string{"sk-test"}), CreateHandler(memoryStorage))
+
+	w := postAlias(handler, "team-wifi", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"))
+
+	w = postAlias(handler, "team-wifi", "sk-wrong")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, 0, memoryStorage.Len())
+}
+
+func TestCreateHandler_InvalidAlias(t *testing.T) {
+	handler := middleware.AuthMiddleware(middleware.NewAPIKeys([]string{"sk-test"}), CreateHandler(storage.NewMemoryStorage()))
+	for _, alias := range []string{"ab", strings.Repeat("a", 65), "Team-Wifi", "team wifi", "../create", "-team", "api", "create", "static"} {
+		w := postAlias(handler, alias, "sk-test")
+		assert.Equal(t, http.StatusNotAcceptable, w.Code, alias)
+	}
+}
+
+func TestUI_RefusesAnonymousAlias(t *testing.T) {
+	memoryStorage := storage.NewMemoryStorage()
+	mux := newUIMux(memoryStorage)
+	form := url.Values{"secret": []string{"x"}, "alias": []string{"sneaky"}}
+	req := httptest.NewRequest("POST", "/new", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, 0, memoryStorage.Len())
+}
+
+func TestRedirectHandler_AllowedNetworks(t *testing.T) {
+	writer := new(MockWriter)
+	writer.On("WriteMessages", mock.Anything, mock.Anything).Return(nil)
+	writer.On("Close").Return(nil)
+	Events = NewPublisherWithWriter(writer, 10)
+	defer func() { Events = nil }()
+
+	memoryStorage := storage.NewMemoryStorage()
+	proxies, _ := middleware.ParseTrustedProxies([]string{"192.0.2.1"})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/create", CreateHandler(memoryStorage))
+	mux.HandleFunc("/", RedirectHandler(memoryStorage))
+	handler := middleware.ProxyMiddleware(proxies, mux)
+
+	form := url.Values{"secret": []string{"vpn only"}, "maxviews": []string{"1"}, "allow": []string{"10.0.0.0/8, 2001:db8::/32", "198.51.100.7"}}
+	req := httptest.NewRequest("POST", "/create", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var created CreateResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	link, _ := memoryStorage.Get(created.Key)
+	assert.Len(t, link.AllowedNets, 3)
+
+	before := testutil.ToFloat64(metrics.SecretsDenied)
+	open := func(remoteAddr, forwardedFor string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/"+created.Key, nil)
+		req.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	// X-Forwarded-For is only believed from the trusted proxy.
+	assert.Equal(t, http.StatusForbidden, open("203.0.113.5:4000", "").Code)
+	assert.Equal(t, http.StatusForbidden, open("203.0.113.5:4000", "10.1.2.3").Code)
+	link, _ = memoryStorage.Get(created.Key)
+	assert.Equal(t, 0, link.Views, "refused requests use up no view")
+	assert.Equal(t, before+2, testutil.ToFloat64(metrics.SecretsDenied))
+
+	w = open("192.0.2.1:4000", "10.1.2.3")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "vpn only", w.Body.String())
+
+	assert.NoError(t, Events.Close(context.Background()))
+	var topics []string
+	for _, call := range writer.Calls {
+		if call.Method == "WriteMessages" {
+			topics = append(topics, call.Arguments[1].([]kafka.Message)[0].Topic)
+		}
+	}
+	assert.Equal(t, []string{Topics.NewLinks, Topics.DeniedLinks, Topics.DeniedLinks, Topics.UpdateLinks}, topics)
+}
+
+func TestAllowedFrom(t *testing.T) {
+	nets, err := parseAllowedNets([]string{"10.0.0.0/8,::ffff:192.0.2.0/120", "2001:db8::1"})
+	assert.NoError(t, err)
+	assert.True(t, allowedFrom(nil, "203.0.113.5"), "unrestricted links are open to all")
+	assert.True(t, allowedFrom(nets, "10.200.0.1"))
+	assert.True(t, allowedFrom(nets, "::ffff:10.0.0.1"))
+	assert.True(t, allowedFrom(nets, "192.0.2.99"))
+	assert.True(t, allowedFrom(nets, "2001:db8::1"))
+	assert.False(t, allowedFrom(nets, "2001:db8::2"))
+	assert.False(t, allowedFrom(nets, "11.0.0.1"))
+	assert.False(t, allowedFrom(nets, ""))
+}
+
+func TestCreateHandler_InvalidAllowedNetworks(t *testing.T) {
+	for _, allow := range []string{"10.0.0.0/33", "intranet", strings.Repeat("10.0.0.1,", maxAllowedNets+1)} {
+		memoryStorage := storage.NewMemoryStorage()
+		form := url.Values{"secret": []string{"x"}, "allow": []string{allow}}
+		req := httptest.NewRequest("POST", "/create", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		CreateHandler(memoryStorage)(w, req)
+
+		assert.Equal(t, http.StatusNotAcceptable, w.Code, allow)
+		assert.Equal(t, 0, memoryStorage.Len())
+	}
+}
+
+func TestRedirectHandler_NotBefore(t *testing.T) {
+	memoryStorage := storage.NewMemoryStorage()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/create", CreateHandler(memoryStorage))
+	mux.HandleFunc("/", RedirectHandler(memoryStorage))
+	mux.HandleFunc("GET /api/links/{key}", StatusHandler(memoryStorage))
+
+	monday := time.Now().Add(72 * time.Hour).Truncate(time.Second).UTC()
+	form := url.Values{"secret": []string{"contractor creds"}, "expiration": []string{"60"}, "not_before": []string{monday.Format(time.RFC3339)}}
+	req := httptest.NewRequest("POST", "/create", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var created CreateResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	if assert.NotNil(t, created.NotBefore) {
+		assert.True(t, monday.Equal(*created.NotBefore))
+	}
+	assert.True(t, monday.Add(time.Hour).Equal(created.ExpiresAt), "expiration counts from activation")
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/"+created.Key, nil))
+	assert.Equal(t, http.StatusTooEarly, w.Code)
+	assert.NotContains(t, w.Body.String(), "contractor creds")
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/api/links/"+created.Key, nil))
+	var status StatusResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.Equal(t, StatePending, status.State)
+	assert.Equal(t, 0, status.Views, "early requests use up no view")
+
+	// Monday comes.
+	link, _ := memoryStorage.Get(created.Key)
+	link.NotBefore = time.Now().Add(-time.Minute)
+	memoryStorage.Update(created.Key, link)
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/"+created.Key, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "contractor creds", w.Body.String())
+}
+
+func TestCreateHandler_InvalidNotBefore(t *testing.T) {
+	farFuture := time.Now().Add(2 * 366 * 24 * time.Hour).Format(time.RFC3339)
+	for _, value := range []string{"monday", "2025-07-21 09:00", farFuture} {
+		memoryStorage := storage.NewMemoryStorage()
+		form := url.Values{"secret": []string{"x"}, "not_before": []string{value}}
+		req := httptest.NewRequest("POST", "/create", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		CreateHandler(memoryStorage)(w, req)
+
+		assert.Equal(t, http.StatusNotAcceptable, w.Code, value)
+		assert.Equal(t, 0, memoryStorage.Len())
+	}
+}
+
+// webhookReceiver records the events of verified deliveries.
+type webhookReceiver struct {
+	mu     sync.Mutex
+	secret map[string]string // by link key
+	events []string
+}
+
+func (rc *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	var payload webhooks.Payload
+	json.Unmarshal(body, &payload)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if err := webhooks.Verify(rc.secret[payload.Key], r.Header, body, time.Minute); err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	rc.events = append(rc.events, payload.Key+" "+payload.Event)
+}
+
+func TestWebhooks(t *testing.T) {
+	Webhooks = webhooks.NewDispatcher(webhooks.Options{Client: webhooks.NewHTTPClient(time.Second, true)})
+	defer func() { Webhooks = nil }()
+	receiver := &webhookReceiver{secret: map[string]string{}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	memoryStorage := storage.NewMemoryStorage()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/create", CreateHandler(memoryStorage))
+	mux.HandleFunc("/", RedirectHandler(memoryStorage))
+	mux.HandleFunc("DELETE /api/links/{key}", RevokeHandler(memoryStorage))
+	create := func(maxViews string) CreateResponse {
+		form := url.Values{"secret": []string{"hooked"}, "maxviews": []string{maxViews}, "webhook": []string{server.URL + "/hook"}}
+		req := httptest.NewRequest("POST", "/create", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var created CreateResponse
+		json.Unmarshal(w.Body.Bytes(), &created)
+		assert.NotEmpty(t, created.WebhookSecret)
+		assert.Equal(t, created.WebhookSecret, w.Header().Get(WebhookSecretHeader))
+		receiver.mu.Lock()
+		receiver.secret[created.Key] = created.WebhookSecret
+		receiver.mu.Unlock()
+		return created
+	}
+
+	viewed := create("2")
+	for range 2 {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", "/"+viewed.Key, nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	revoked := create("1")
+	req := httptest.NewRequest("DELETE", "/api/links/"+revoked.Key, nil)
+	req.Header.Set(RevokeTokenHeader, revoked.RevokeToken)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	expired := create("1")
+	Sweep(context.Background(), memoryStorage, expired.ExpiresAt.Add(time.Second))
+
+	assert.NoError(t, Webhooks.Close(context.Background()))
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+	assert.ElementsMatch(t, []string{
+		viewed.Key + " viewed",
+		viewed.Key + " viewed",
+		viewed.Key + " exhausted",
+		revoked.Key + " revoked",
+		expired.Key + " expired",
+	}, receiver.events)
+	for _, attempt := range Webhooks.Log().Recent() {
+		assert.Equal(t, webhooks.ResultDelivered, attempt.Result)
+	}
+}
+
+func TestCreateHandler_InvalidWebhook(t *testing.T) {
+	form := url.Values{"secret": []string{"x"}, "webhook": []string{"https://hooks.example.com/"}}
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest("POST", "/create", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req
+	}
+	w := httptest.NewRecorder()
+	CreateHandler(storage.NewMemoryStorage())(w, newRequest())
+	assert.Equal(t, http.StatusNotAcceptable, w.Code, "webhooks need a dispatcher")
+
+	Webhooks = webhooks.NewDispatcher(webhooks.Options{})
+	defer func() { Webhooks.Close(context.Background()); Webhooks = nil }()
+	form.Set("webhook", "javascript:alert(1)")
+	w = httptest.NewRecorder()
+	CreateHandler(storage.NewMemoryStorage())(w, newRequest())
+	assert.Equal(t, http.StatusNotAcceptable, w.Code)
+}
+
+func TestEmailNotifications(t *testing.T) {
+	dir := t.TempDir()
+	Notifications = notify.NewQueue(&notify.FileDrop{Dir: dir, From: "links@example.com"}, 10, time.Second)
+	defer func() { Notifications = nil }()
+
+	memoryStorage := storage.NewMemoryStorage()
+	mux := http.NewServeMux()
+	mux.Handle("/create", middleware.AuthMiddleware(middleware.NewAPIKeys([]string{"sk-test"}), CreateHandler(memoryStorage)))
+	mux.HandleFunc("/", RedirectHandler(memoryStorage))
+	create := func() CreateResponse {
+		form := url.Values{"secret": []string{"mailed"}, "maxviews": []string{"2"}, "notify": []string{"Alice <alice@example.com>"}}
+		req := httptest.NewRequest("POST", "/create", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("Authorization", "Bearer sk-test")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var created CreateResponse
+		json.Unmarshal(w.Body.Bytes(), &created)
+		return created
+	}
+
+	viewed := create()
+	unread := create()
+	link, _ := memoryStorage.Get(viewed.Key)
+	assert.Equal(t, "alice@example.com", link.NotifyEmail)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/"+viewed.Key, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Both expire; only the unread one is worth an email.
+	Sweep(context.Background(), memoryStorage, unread.ExpiresAt.Add(time.Second))
+	assert.NoError(t, Notifications.Close(context.Background()))
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	var subjects []string
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		assert.NoError(t, err)
+		assert.Contains(t, string(data), "To: alice@example.com\r\n")
+		assert.NotContains(t, string(data), "mailed", "emails never contain the secret")
+		assert.NotContains(t, string(data), viewed.Key, "nor the key that opens it")
+		assert.NotContains(t, string(data), unread.Key)
+		for _, line := range strings.Split(string(data), "\r\n") {
+			if subject, ok := strings.CutPrefix(line, "Subject: "); ok {
+				subjects = append(subjects, subject)
+			}
+		}
+	}
+	assert.ElementsMatch(t, []string{
+		"Your secret " + middleware.RedactKey(viewed.Key) + " was viewed",
+		"Your secret " + middleware.RedactKey(unread.Key) + " expired unread",
+	}, subjects)
+}
+
+func TestCreateHandler_NotifyRequiresAPIKey(t *testing.T) {
+	Notifications = notify.NewQueue(&notify.FileDrop{Dir: t.TempDir()}, 1, time.Second)
+	defer func() { Notifications.Close(context.Background()); Notifications = nil }()
+	memoryStorage := storage.NewMemoryStorage()
+
+	form := url.Values{"secret": []string{"x"}, "notify": []string{"victim@example.com"}}
+	req := httptest.NewRequest("POST", "/create", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	middleware.AuthMiddleware(middleware.NewAPIKeys([]string{"sk-test"}), CreateHandler(memoryStorage)).ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"))
+	assert.Equal(t, 0, memoryStorage.Len())
+}
+
+func TestCreateHandler_InvalidNotify(t *testing.T) {
+	form := url.Values{"secret": []string{"x"}, "notify": []string{"alice@example.com"}}
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest("POST", "/create", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req
+	}
+	w := httptest.NewRecorder()
+	CreateHandler(storage.NewMemoryStorage())(w, newRequest())
+	assert.Equal(t, http.StatusNotAcceptable, w.Code, "notifications need a queue")
+
+	Notifications = notify.NewQueue(&notify.FileDrop{Dir: t.TempDir()}, 1, time.Second)
+	defer func() { Notifications.Close(context.Background()); Notifications = nil }()
+	form.Set("notify", "not an address")
+	w = httptest.NewRecorder()
+	CreateHandler(storage.NewMemoryStorage())(w, newRequest())
+	assert.Equal(t, http.StatusNotAcceptable, w.Code)
+}
+
+func TestCreateHandler_Recipients(t *testing.T) {
+	memoryStorage := storage.NewMemoryStorage()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/create", CreateHandler(memoryStorage))
+	mux.HandleFunc("/", RedirectHandler(memoryStorage))
+	mux.HandleFunc("DELETE /api/links/{key}", RevokeHandler(memoryStorage))
+	mux.HandleFunc("GET /api/groups/{id}", GroupStatusHandler(memoryStorage))
+
+	form := url.Values{"secret": []string{"db password"}, "recipient": []string{"alice, bob", "carol"}}
+	req := httptest.NewRequest("POST", "/create", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	var created GroupResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	require.Len(t, created.Links, 3)
+	assert.NotEmpty(t, created.GroupToken)
+	tokens := make(map[string]bool)
+	for i, recipient := range []string{"alice", "bob", "carol"} {
+		link := created.Links[i]
+		assert.Equal(t, recipient, link.Recipient)
+		assert.Equal(t, "http://example.com/"+link.Key, link.URL)
+		tokens[link.RevokeToken] = true
+		stored, exists := memoryStorage.Get(link.Key)
+		require.True(t, exists)
+		assert.Equal(t, created.Group, stored.Group)
+	}
+	assert.Len(t, tokens, 3, "every link has its own revoke token")
+	alice, _ := memoryStorage.Get(created.Links[0].Key)
+	bob, _ := memoryStorage.Get(created.Links[1].Key)
+	assert.Equal(t, alice.Secret, bob.Secret, "the secret is encrypted once")
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/"+created.Links[0].Key, nil))
+	assert.Equal(t, "db password", w.Body.String())
+	req = httptest.NewRequest("DELETE", "/api/links/"+created.Links[1].Key, nil)
+	req.Header.Set(RevokeTokenHeader, created.Links[1].RevokeToken)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	status := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/groups/"+created.Group, nil)
+		req.Header.Set(GroupTokenHeader, token)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+	assert.Equal(t, http.StatusForbidden, status("").Code)
+	assert.Equal(t, http.StatusForbidden, status(created.Links[0].RevokeToken).Code)
+	w = status(created.GroupToken)
+	require.Equal(t, http.StatusOK, w.Code)
+	var group GroupStatusResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &group))
+	require.Len(t, group.Members, 3)
+	assert.Equal(t, StateExpired, group.Members[0].State)
+	assert.Equal(t, 1, group.Members[0].Views)
+	assert.NotNil(t, group.Members[0].OpenedAt)
+	assert.Equal(t, StateRevoked, group.Members[1].State)
+	assert.Nil(t, group.Members[1].OpenedAt)
+	assert.Equal(t, StateActive, group.Members[2].State)
+	assert.Equal(t, "carol", group.Members[2].Recipient)
+}
+
+func TestCreateHandler_RecipientsPlainText(t *testing.T) {
+	form := url.Values{"secret": []string{"x"}, "recipient": []string{"Alice Smith", "bob"}}
+	req := httptest.NewRequest("POST", "/create", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	CreateHandler(storage.NewMemoryStorage())(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, w.Header().Get(GroupHeader))
+	assert.NotEmpty(t, w.Header().Get(GroupTokenHeader))
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.True(t, strings.HasPrefix(lines[0], "Alice Smith\thttp://example.com/"))
+	assert.True(t, strings.HasPrefix(lines[1], "bob\thttp://example.com/"))
+}
+
+func TestCreateHandler_InvalidRecipients(t *testing.T) {
+	for name, form := range map[string]url.Values{
+		"duplicate": {"recipient": []string{"alice", "alice"}},
+		"too long":  {"recipient": []string{strings.Repeat("a", maxRecipientLength+1)}},
+		"too many":  {"recipient": []string{strings.Repeat("r,", maxRecipients) + "last"}},
+		"newline":   {"recipient": []string{"alice\nhttp://evil.example/x"}},
+		"tab":       {"recipient": []string{"alice\tbob"}},
+		"with qr":   {"recipient": []string{"alice"}, "qr": []string{"png"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			form.Set("secret", "x")
+			req := httptest.NewRequest("POST", "/create", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			CreateHandler(storage.NewMemoryStorage())(w, req)
+			assert.Equal(t, http.StatusNotAcceptable, w.Code)
+		})
+	}
+
+	// Storages without groups cannot hold them.
+	form := url.Values{"secret": []string{"x"}, "recipient": []string{"alice"}}
+	req := httptest.NewRequest("POST", "/create", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	CreateHandler(new(MockStorage))(w, req)
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+}
@@ -2,37 +2,148 @@ package handlers
 
 import (
 	"context"
-	"encoding/json"
+	"log"
+	"secretlinks/config"
+	"secretlinks/events"
+	"secretlinks/metrics"
+	"secretlinks/tracing"
+	"sync"
 	"time"
 
 	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
-type KafkaStatsItem struct {
-	LinkKey string    `json:"linkkey"`
-	NowTime time.Time `json:"nowtime"`
+type KafkaStatsItem = events.LinkEvent
+
+// StatsContentType selects how stats events are encoded on the wire.
+// Consumers read the content-type header, so it can be switched at any time.
+var StatsContentType = events.ContentTypeJSON
+
+// Topics names the kafka topic of each kind of stats event.
+var Topics = config.Default().Kafka.Topics
+
+// Events receives every stats event. Without a publisher events are dropped,
+// which is what tests and setups without kafka want.
+var Events *Publisher
+
+type MessageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
 }
 
-func SendStats(resultKey, topic string) {
-	writer := kafka.NewWriter(kafka.WriterConfig{
-		Brokers: []string{"localhost:9092"},
-		Topic:   topic,
-	})
-	defer writer.Close()
+// Publisher queues stats events and writes them to kafka in the background,
+// so a slow or unavailable broker does not hold up HTTP requests.
+type Publisher struct {
+	writer MessageWriter
+	queue  chan kafka.Message
+	done   chan struct{}
+	mu     sync.RWMutex
+	closed bool
+}
 
-	statEvent := KafkaStatsItem{
-		LinkKey: resultKey,
-		NowTime: time.Now(),
-	}
+func NewPublisher(brokers []string, queueSize int) *Publisher {
+	return NewPublisherWithWriter(&kafka.Writer{
+		Addr:                   kafka.TCP(brokers...),
+		Balancer:               &kafka.Hash{},
+		AllowAutoTopicCreation: true,
+	}, queueSize)
+}
 
-	jsonOrder, _ := json.Marshal(statEvent)
-	message := kafka.Message{
-		Key:   []byte(statEvent.LinkKey),
-		Value: jsonOrder,
+func NewPublisherWithWriter(writer MessageWriter, queueSize int) *Publisher {
+	p := &Publisher{
+		writer: writer,
+		queue:  make(chan kafka.Message, queueSize),
+		done:   make(chan struct{}),
 	}
-	err := writer.WriteMessages(context.Background(), message)
+	go p.run()
+	return p
+}
+
+// Publish queues the event for the topic. It never blocks: when the queue
+// is full the event is dropped and counted as a failure. The trace context
+// of ctx travels in the message headers.
+func (p *Publisher) Publish(ctx context.Context, topic string, event KafkaStatsItem) {
+	ctx, span := tracer.Start(ctx, "publish "+topic, trace.WithSpanKind(trace.SpanKindProducer))
+	defer span.End()
+
+	message, err := events.NewMessage(event, StatsContentType)
 	if err != nil {
-		panic(err)
+		log.Printf("Stats encode error (topic %s): %v", topic, err)
+		metrics.EventPublishFailures.WithLabelValues(topic, "encode").Inc()
+		span.SetStatus(codes.Error, "encode")
+		return
 	}
+	message.Topic = topic
+	tracing.InjectKafka(ctx, &message)
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		metrics.EventPublishFailures.WithLabelValues(topic, "closed").Inc()
+		return
+	}
+	select {
+	case p.queue <- message:
+		metrics.EventQueueDepth.Set(float64(len(p.queue)))
+	default:
+		log.Printf("Stats queue full, dropping event (topic %s)", topic)
+		metrics.EventPublishFailures.WithLabelValues(topic, "queue_full").Inc()
+		span.SetStatus(codes.Error, "queue full")
+	}
+}
 
+// Pending returns the number of queued events.
+func (p *Publisher) Pending() int {
+	return len(p.queue)
+}
+
+// Close stops accepting events, writes the queued ones and closes the writer.
+// It gives up when ctx is done.
+func (p *Publisher) Close(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.mu.Unlock()
+
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+		p.writer.Close()
+		return ctx.Err()
+	}
+	return p.writer.Close()
+}
+
+func (p *Publisher) run() {
+	defer close(p.done)
+	for message := range p.queue {
+		metrics.EventQueueDepth.Set(float64(len(p.queue)))
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err := p.writer.WriteMessages(ctx, message)
+		cancel()
+		if err != nil {
+			log.Printf("Stats write error (topic %s): %v", message.Topic, err)
+			metrics.EventPublishFailures.WithLabelValues(message.Topic, "write").Inc()
+			continue
+		}
+		metrics.EventsPublished.WithLabelValues(message.Topic).Inc()
+	}
+}
+
+func SendStats(ctx context.Context, resultKey, topic string) {
+	SendEvent(ctx, KafkaStatsItem{
+		LinkKey: resultKey,
+		NowTime: time.Now(),
+	}, topic)
+}
+
+func SendEvent(ctx context.Context, statEvent KafkaStatsItem, topic string) {
+	if Events == nil {
+		return
+	}
+	Events.Publish(ctx, topic, statEvent)
 }
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"secretlinks/middleware"
+	"secretlinks/notify"
+	"secretlinks/storage"
+	"secretlinks/webhooks"
+	"time"
+)
+
+// WebhookSecretHeader carries the secret that signs the webhooks of a new
+// link; JSON answers also have it in webhook_secret.
+const WebhookSecretHeader = "X-Webhook-Secret"
+
+// Webhooks delivers the callbacks links were created with. Without a
+// dispatcher /create refuses webhook URLs.
+var Webhooks *webhooks.Dispatcher
+
+// Notifications emails creators who gave an address. Without a queue
+// /create refuses notification addresses.
+var Notifications *notify.Queue
+
+// notifyCreator tells the creator of link about event, one of the
+// webhooks events, through the channels they chose. Emails are only sent
+// for views and for links that expired unread.
+func notifyCreator(key string, link storage.Link, event string) {
+	now := time.Now().UTC()
+	if Webhooks != nil && link.WebhookURL != "" {
+		Webhooks.Send(webhooks.Hook{URL: link.WebhookURL, Secret: link.WebhookSecret}, webhooks.Payload{
+			Event:      event,
+			Key:        key,
+			OccurredAt: now,
+			Views:      link.Views,
+			MaxViews:   link.MaxViews,
+			ExpiresAt:  link.ExpiresAt,
+		})
+	}
+
+	if Notifications == nil || link.NotifyEmail == "" {
+		return
+	}
+	n := notify.Notification{To: link.NotifyEmail, ID: middleware.RedactKey(key), At: now, Views: link.Views, MaxViews: link.MaxViews}
+	switch {
+	case event == webhooks.EventViewed:
+		n.Event = notify.EventViewed
+	case event == webhooks.EventExpired && link.Views == 0:
+		n.Event = notify.EventExpired
+	default:
+		return
+	}
+	Notifications.Send(n)
+}
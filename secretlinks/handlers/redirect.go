@@ -1,39 +1,123 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"secretlinks/events"
+	"secretlinks/metrics"
 	"secretlinks/middleware"
 	"secretlinks/storage"
+	"secretlinks/webhooks"
+	"strings"
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Returned by openLink.
+var (
+	ErrLinkNotFound  = errors.New("link not found")
+	ErrLinkExpired   = errors.New("link expired")
+	ErrLinkNotActive = errors.New("link not yet available")
 )
 
 func RedirectHandler(s storage.Storage) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		key := r.URL.Path[1:]
-		link, exists := s.Get(key)
+		key := strings.TrimPrefix(r.URL.Path, BasePath())
+		ctx, span := tracer.Start(r.Context(), "RedirectHandler")
+		defer span.End()
 
-		if !exists {
+		secret, err := openLink(ctx, s, key, middleware.ClientIP(r))
+		switch {
+		case errors.Is(err, ErrLinkNotFound):
 			http.NotFound(w, r)
 			return
-		}
-
-		if link.Views >= link.MaxViews {
-			http.Error(w, "Link expired", http.StatusGone)
-			s.Delete(key)
+		case errors.Is(err, ErrLinkDenied):
+			http.Error(w, "Link not available from your network", http.StatusForbidden)
 			return
-		}
-
-		if time.Now().After(link.ExpiresAt) {
+		case errors.Is(err, ErrLinkNotActive):
+			http.Error(w, "Link not yet available", http.StatusTooEarly)
+			return
+		case errors.Is(err, ErrLinkExpired):
 			http.Error(w, "Link expired", http.StatusGone)
-			s.Delete(key)
 			return
 		}
 
-		link.Views++
-		s.Update(key, link)
-		SendStats(key, "updatelinks")
-
 		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(middleware.DecryptText(link.Secret)))
+		w.Write([]byte(secret))
+	}
+}
+
+// openLink uses up one view of the link and returns its decrypted secret.
+// A link found exhausted or past its expiration is deleted; a link before
+// its NotBefore time is left untouched. A client
+// outside the link's networks is refused before anything else, so it uses
+// up no view and learns nothing about the link's state.
+func openLink(ctx context.Context, s storage.Storage, key, clientIP string) (string, error) {
+	trace.SpanFromContext(ctx).SetAttributes(keyAttribute(key))
+	groups := s
+	s = traceStorage(ctx, s)
+
+	link, exists := s.Get(key)
+
+	if !exists {
+		return "", ErrLinkNotFound
 	}
+
+	if !allowedFrom(link.AllowedNets, clientIP) {
+		sendDenied(ctx, key)
+		return "", ErrLinkDenied
+	}
+
+	if link.Views >= link.MaxViews {
+		s.Delete(key)
+		sendExpired(ctx, key, events.ReasonViews)
+		return "", ErrLinkExpired
+	}
+
+	if time.Now().After(link.ExpiresAt) {
+		s.Delete(key)
+		sendExpired(ctx, key, events.ReasonTime)
+		notifyCreator(key, link, webhooks.EventExpired)
+		return "", ErrLinkExpired
+	}
+
+	if time.Now().Before(link.NotBefore) {
+		return "", ErrLinkNotActive
+	}
+
+	link.Views++
+	s.Update(key, link)
+	updateMember(groups, key, link, func(m *storage.Member) {
+		m.Views = link.Views
+		if m.OpenedAt.IsZero() {
+			m.OpenedAt = time.Now().UTC()
+		}
+	})
+	metrics.SecretsConsumed.Inc()
+	SendStats(ctx, key, Topics.UpdateLinks)
+	notifyCreator(key, link, webhooks.EventViewed)
+	if link.Views >= link.MaxViews {
+		notifyCreator(key, link, webhooks.EventExhausted)
+	}
+
+	return decrypt(ctx, link.Secret), nil
+}
+
+func sendExpired(ctx context.Context, key, reason string) {
+	metrics.SecretsExpired.WithLabelValues(reason).Inc()
+	SendEvent(ctx, KafkaStatsItem{
+		LinkKey: key,
+		NowTime: time.Now(),
+		Reason:  reason,
+	}, Topics.ExpiredLinks)
+}
+
+func sendDenied(ctx context.Context, key string) {
+	metrics.SecretsDenied.Inc()
+	SendEvent(ctx, KafkaStatsItem{
+		LinkKey: key,
+		NowTime: time.Now(),
+	}, Topics.DeniedLinks)
 }
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"secretlinks/events"
+	"secretlinks/storage"
+	"secretlinks/webhooks"
+	"time"
+)
+
+// ExpiringStorage is implemented by storages that can drop links whose
+// time ran out, such as storage.MemoryStorage.
+type ExpiringStorage interface {
+	DeleteExpired(now time.Time) map[string]storage.Link
+}
+
+// RunSweeper removes expired links every interval, so links nobody opens
+// again do not stay in memory, and reports each one as expired. It returns
+// when ctx is cancelled.
+func RunSweeper(ctx context.Context, s ExpiringStorage, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			Sweep(ctx, s, now)
+		}
+	}
+}
+
+// Sweep removes the links that expired before now and returns how many
+// there were.
+func Sweep(ctx context.Context, s ExpiringStorage, now time.Time) int {
+	ctx, span := tracer.Start(ctx, "Sweep")
+	defer span.End()
+
+	expired := s.DeleteExpired(now)
+	for key, link := range expired {
+		// A link whose last view was used up stays stored until its
+		// time runs out, but it ended with that view; its creator
+		// already heard about it.
+		if link.Views >= link.MaxViews {
+			sendExpired(ctx, key, events.ReasonViews)
+			continue
+		}
+		sendExpired(ctx, key, events.ReasonTime)
+		notifyCreator(key, link, webhooks.EventExpired)
+	}
+	if len(expired) > 0 {
+		slog.InfoContext(ctx, "expired links swept", "count", len(expired))
+	}
+	return len(expired)
+}
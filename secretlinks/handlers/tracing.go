@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"context"
+	"secretlinks/middleware"
+	"secretlinks/storage"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var tracer = otel.Tracer("secretlinks/handlers")
+
+func keyAttribute(key string) attribute.KeyValue {
+	return attribute.String("link.key_hash", middleware.RedactKey(key))
+}
+
+// tracedStorage wraps every storage call in a span. Storage methods take
+// no context, so the parent comes from the request the wrapper is built for.
+type tracedStorage struct {
+	ctx context.Context
+	s   storage.Storage
+}
+
+func traceStorage(ctx context.Context, s storage.Storage) tracedStorage {
+	return tracedStorage{ctx: ctx, s: s}
+}
+
+func (t tracedStorage) Create(key string, link storage.Link, b bool) bool {
+	_, span := tracer.Start(t.ctx, "storage.Create")
+	defer span.End()
+	unique := t.s.Create(key, link, b)
+	span.SetAttributes(attribute.Bool("link.unique", unique))
+	return unique
+}
+
+func (t tracedStorage) Update(key string, link storage.Link) {
+	_, span := tracer.Start(t.ctx, "storage.Update")
+	defer span.End()
+	t.s.Update(key, link)
+}
+
+func (t tracedStorage) Get(key string) (storage.Link, bool) {
+	_, span := tracer.Start(t.ctx, "storage.Get")
+	defer span.End()
+	link, exists := t.s.Get(key)
+	span.SetAttributes(attribute.Bool("link.exists", exists))
+	return link, exists
+}
+
+func (t tracedStorage) Delete(key string) {
+	_, span := tracer.Start(t.ctx, "storage.Delete")
+	defer span.End()
+	t.s.Delete(key)
+}
+
+func encrypt(ctx context.Context, text string) string {
+	_, span := tracer.Start(ctx, "encrypt")
+	defer span.End()
+	return middleware.EncryptText(text)
+}
+
+func decrypt(ctx context.Context, ciphertext string) string {
+	_, span := tracer.Start(ctx, "decrypt")
+	defer span.End()
+	return middleware.DecryptText(ciphertext)
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"secretlinks/middleware"
+	"secretlinks/qr"
+	"secretlinks/storage"
+	"secretlinks/web"
+	"time"
+)
+
+// expirationChoices are offered by the create form, in minutes.
+var expirationChoices = []int{5, 60, 24 * 60, 7 * 24 * 60}
+
+type expirationOption struct {
+	Minutes  int
+	Label    string
+	Selected bool
+}
+
+// page is the data every template gets; each page uses its own fields.
+type page struct {
+	Base string
+
+	Error       string
+	Secret      string
+	Expirations []expirationOption
+	MaxViews    int
+
+	Link      string
+	ExpiresAt time.Time
+	QR        template.HTML
+
+	Message string
+}
+
+func expirationOptions(selected int) []expirationOption {
+	choices := expirationChoices
+	found := false
+	for _, minutes := range choices {
+		found = found || minutes == selected
+	}
+	if !found {
+		choices = append([]int{selected}, choices...)
+	}
+	options := make([]expirationOption, len(choices))
+	for i, minutes := range choices {
+		options[i] = expirationOption{Minutes: minutes, Label: expirationLabel(minutes), Selected: minutes == selected}
+	}
+	return options
+}
+
+func expirationLabel(minutes int) string {
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return fmt.Sprintf("1 %s", unit)
+		}
+		return fmt.Sprintf("%d %ss", n, unit)
+	}
+	switch {
+	case minutes%(24*60) == 0:
+		return plural(minutes/(24*60), "day")
+	case minutes%60 == 0:
+		return plural(minutes/60, "hour")
+	default:
+		return plural(minutes, "minute")
+	}
+}
+
+func render(w http.ResponseWriter, r *http.Request, status int, name string, data page) {
+	data.Base = BasePath()
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := web.Render(w, name, data); err != nil {
+		middleware.Logger(r.Context()).Error("render page", "page", name, "error", err)
+	}
+}
+
+// UIFormHandler shows the form for a new secret.
+func UIFormHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		render(w, r, http.StatusOK, "create.html", page{
+			Expirations: expirationOptions(DefaultExpiration),
+			MaxViews:    DefaultMaxViews,
+		})
+	}
+}
+
+// UICreateHandler creates a link from the form and shows it, ready to copy.
+// The link leads to the reveal page rather than to the raw secret.
+func UICreateHandler(s storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := tracer.Start(r.Context(), "UICreateHandler")
+		defer span.End()
+
+		req, err := parseCreateRequest(r)
+		if err == nil && (req.Expiration < 1 || req.MaxViews < 1) {
+			err = errors.New("Expiration and views must be at least 1")
+		}
+		// The form has no alias or notification fields; they are for API
+		// callers.
+		if err == nil && req.Alias != "" && !middleware.Authenticated(ctx) {
+			err = errors.New("An API key is required to choose an alias")
+		}
+		if err == nil && (req.Webhook != "" || req.NotifyEmail != "") {
+			err = errors.New("Notifications can only be requested through the API")
+		}
+		if err == nil && len(req.Recipients) > 0 {
+			err = errors.New("Links for several recipients can only be created through the API")
+		}
+		if err != nil {
+			render(w, r, http.StatusBadRequest, "create.html", page{
+				Error:       err.Error(),
+				Secret:      r.FormValue("secret"),
+				Expirations: expirationOptions(DefaultExpiration),
+				MaxViews:    DefaultMaxViews,
+			})
+			return
+		}
+
+		key, link, _, err := createLink(ctx, s, req)
+		if err != nil {
+			render(w, r, http.StatusConflict, "create.html", page{
+				Error:       err.Error(),
+				Secret:      r.FormValue("secret"),
+				Expirations: expirationOptions(DefaultExpiration),
+				MaxViews:    DefaultMaxViews,
+			})
+			return
+		}
+		data := page{
+			Link:      LinkURL(r, "s/"+key),
+			ExpiresAt: link.ExpiresAt,
+			MaxViews:  link.MaxViews,
+		}
+		// Inline SVG needs no image request, so the page stays within its
+		// Content-Security-Policy and nothing is cached.
+		if image, err := qr.Encode(data.Link, qr.SVG); err == nil {
+			data.QR = template.HTML(image)
+		}
+		render(w, r, http.StatusOK, "result.html", data)
+	}
+}
+
+// UIRevealHandler serves the page a recipient opens. GET only asks for
+// confirmation, so link previews and scanners do not use up a view; the
+// secret is shown in answer to the POST of that page.
+func UIRevealHandler(s storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.PathValue("key")
+		ctx, span := tracer.Start(r.Context(), "UIRevealHandler")
+		defer span.End()
+		span.SetAttributes(keyAttribute(key))
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			link, exists := traceStorage(ctx, s).Get(key)
+			if !exists || link.Views >= link.MaxViews || time.Now().After(link.ExpiresAt) {
+				render(w, r, http.StatusNotFound, "error.html", page{
+					Message: "This secret does not exist, has expired or was already viewed.",
+				})
+				return
+			}
+			render(w, r, http.StatusOK, "reveal.html", page{})
+
+		case http.MethodPost:
+			secret, err := openLink(ctx, s, key, middleware.ClientIP(r))
+			switch {
+			case errors.Is(err, ErrLinkNotFound):
+				render(w, r, http.StatusNotFound, "error.html", page{
+					Message: "This secret does not exist or was already viewed.",
+				})
+				return
+			case errors.Is(err, ErrLinkNotActive):
+				render(w, r, http.StatusTooEarly, "error.html", page{
+					Message: "This secret is not available yet. Try again later.",
+				})
+				return
+			case errors.Is(err, ErrLinkDenied):
+				render(w, r, http.StatusForbidden, "error.html", page{
+					Message: "This secret cannot be opened from your network.",
+				})
+				return
+			case errors.Is(err, ErrLinkExpired):
+				render(w, r, http.StatusGone, "error.html", page{
+					Message: "This secret has expired.",
+				})
+				return
+			}
+			render(w, r, http.StatusOK, "reveal.html", page{Secret: secret})
+
+		default:
+			w.Header().Set("Allow", "GET, HEAD, POST")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
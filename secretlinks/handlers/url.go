@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"secretlinks/middleware"
+	"strings"
+)
+
+// BaseURL is the public address links are served under, such as
+// https://example.com/secrets/. When nil, links are built from the request
+// and the service is served from the root.
+var BaseURL *url.URL
+
+// BasePath is the path prefix of the service, always ending in "/".
+func BasePath() string {
+	if BaseURL == nil || BaseURL.Path == "" {
+		return "/"
+	}
+	return strings.TrimSuffix(BaseURL.Path, "/") + "/"
+}
+
+// LinkURL returns the public URL of a link.
+func LinkURL(r *http.Request, key string) string {
+	if BaseURL != nil {
+		return BaseURL.JoinPath(key).String()
+	}
+	return middleware.Scheme(r) + "://" + middleware.Host(r) + "/" + key
+}
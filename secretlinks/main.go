@@ -1,24 +1,243 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"secretlinks/certs"
+	"secretlinks/config"
+	"secretlinks/events"
 	"secretlinks/handlers"
+	"secretlinks/metrics"
 	"secretlinks/middleware"
+	"secretlinks/notify"
 	"secretlinks/storage"
+	"secretlinks/tracing"
+	"secretlinks/web"
+	"secretlinks/webhooks"
+	"sync"
+	"syscall"
+	"time"
 )
 
 func main() {
+	cfg, err := config.Load("secretlinks", os.Args[1:], os.Stdout)
+	if errors.Is(err, config.ErrPrintConfig) {
+		return
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+	if cfg.KeyIDs.Secret != "" {
+		middleware.SetKeyIDSecret(cfg.KeyIDs.Secret)
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), "secretlinks", cfg.Tracing.Exporter)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer shutdownTracing(context.Background())
+
+	contentType, err := events.ParseEncoding(cfg.Server.StatsEncoding)
+	if err != nil {
+		log.Fatal(err)
+	}
+	handlers.StatsContentType = contentType
+	handlers.Topics = cfg.Kafka.Topics
+	handlers.DefaultExpiration = cfg.Server.DefaultExpiration
+	handlers.DefaultMaxViews = cfg.Server.DefaultMaxViews
+	if cfg.Server.BaseURL != "" {
+		handlers.BaseURL, _ = url.Parse(cfg.Server.BaseURL) // checked by config.Load
+	}
+	handlers.Events = handlers.NewPublisher(cfg.Kafka.Brokers, cfg.Server.EventQueueSize)
+
+	deliveryLog := webhooks.NewDeliveryLog(nil, 1000)
+	if cfg.Server.WebhookLog != "" {
+		deliveryLog, err = webhooks.OpenDeliveryLog(cfg.Server.WebhookLog, 1000)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	defer deliveryLog.Close()
+	retry := webhooks.DefaultRetryPolicy
+	retry.MaxAttempts = cfg.Server.WebhookAttempts
+	handlers.Webhooks = webhooks.NewDispatcher(webhooks.Options{
+		Client:    webhooks.NewHTTPClient(cfg.Server.WebhookTimeout, cfg.Server.WebhookAllowPrivate),
+		Retry:     retry,
+		Log:       deliveryLog,
+		QueueSize: cfg.Server.EventQueueSize,
+	})
+	if cfg.Notify.Enabled() {
+		var notifier notify.Notifier = &notify.SMTP{
+			Addr:     cfg.Notify.SMTPAddr,
+			From:     cfg.Notify.From,
+			Username: cfg.Notify.SMTPUsername,
+			Password: cfg.Notify.SMTPPassword,
+		}
+		if cfg.Notify.Dir != "" {
+			notifier = &notify.FileDrop{Dir: cfg.Notify.Dir, From: cfg.Notify.From}
+		}
+		handlers.Notifications = notify.NewQueue(notifier, cfg.Server.EventQueueSize, 30*time.Second)
+	}
+
 	storage := storage.NewMemoryStorage()
+	if cfg.Server.Snapshot != "" {
+		n, err := storage.LoadSnapshot(cfg.Server.Snapshot)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Loaded %d links from %s", n, cfg.Server.Snapshot)
+	}
+	metrics.RegisterActiveLinks(storage.Len)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Background work stopped after the last request has finished.
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		handlers.RunSweeper(workersCtx, storage, cfg.Server.SweepInterval)
+	}()
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("/create", handlers.CreateHandler(storage))
-	mux.HandleFunc("/", handlers.RedirectHandler(storage))
+	// Responses with secrets or links to them must never be cached or
+	// embedded; /metrics and the static files of the web interface only
+	// need the basics.
+	secretHeaders := middleware.StrictHeaders
+	secretHeaders.HSTSMaxAge = cfg.Server.HSTSMaxAge
+	metricsHeaders := secretHeaders
+	metricsHeaders.NoStore = false
+	metricsHeaders.ContentSecurityPolicy = ""
+
+	pageHeaders := secretHeaders
+	pageHeaders.ContentSecurityPolicy = web.ContentSecurityPolicy
+
+	base := handlers.BasePath()
+	mux.Handle(base+"create", middleware.SecurityHeadersMiddleware(secretHeaders, handlers.CreateHandler(storage)))
+	mux.Handle(base, middleware.SecurityHeadersMiddleware(secretHeaders, handlers.RedirectHandler(storage)))
+	mux.Handle("GET "+base+"api/links/{key}", middleware.SecurityHeadersMiddleware(secretHeaders, handlers.StatusHandler(storage)))
+	mux.Handle("DELETE "+base+"api/links/{key}", middleware.SecurityHeadersMiddleware(secretHeaders, handlers.RevokeHandler(storage)))
+	mux.Handle("GET "+base+"api/groups/{id}", middleware.SecurityHeadersMiddleware(secretHeaders, handlers.GroupStatusHandler(storage)))
+
+	mux.Handle("GET "+base+"{$}", middleware.SecurityHeadersMiddleware(pageHeaders, handlers.UIFormHandler()))
+	mux.Handle("POST "+base+"new", middleware.SecurityHeadersMiddleware(pageHeaders, handlers.UICreateHandler(storage)))
+	mux.Handle(base+"s/{key}", middleware.SecurityHeadersMiddleware(pageHeaders, handlers.UIRevealHandler(storage)))
+	mux.Handle("GET "+base+"static/", middleware.SecurityHeadersMiddleware(metricsHeaders, http.StripPrefix(base+"static/", web.Static())))
+
+	proxies, err := middleware.ParseTrustedProxies(cfg.Server.TrustedProxies)
+	if err != nil {
+		log.Fatal(err)
+	}
+	apiKeys := middleware.NewAPIKeys(cfg.Server.APIKeys)
+	newMux := middleware.ProxyMiddleware(proxies, middleware.TracingMiddleware(middleware.LoggingMiddleware(middleware.AuthMiddleware(apiKeys, middleware.MetricsMiddleware(mux)))))
+
+	server := &http.Server{Addr: cfg.Server.Addr, Handler: newMux}
+	var redirectServer *http.Server
+	if cfg.Server.TLS() {
+		reloader, err := certs.NewReloader(cfg.Server.TLSCert, cfg.Server.TLSKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+		server.TLSConfig = reloader.TLSConfig()
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			reloader.Watch(workersCtx, certs.WatchInterval)
+		}()
+		if cfg.Server.RedirectAddr != "" {
+			redirectServer = &http.Server{Addr: cfg.Server.RedirectAddr, Handler: middleware.RedirectToHTTPS(cfg.Server.Addr)}
+		}
+	}
+
+	// /metrics is not authenticated, so it gets its own, by default
+	// loopback, listener instead of the public one.
+	var metricsServer *http.Server
+	if cfg.Server.MetricsAddr != "" {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", middleware.SecurityHeadersMiddleware(metricsHeaders, metrics.Handler()))
+		metricsServer = &http.Server{Addr: cfg.Server.MetricsAddr, Handler: metricsMux}
+	}
+
+	serverErr := make(chan error, 3)
+	go func() {
+		if server.TLSConfig != nil {
+			log.Println("Server starting with TLS on " + cfg.Server.Addr)
+			serverErr <- server.ListenAndServeTLS("", "")
+			return
+		}
+		log.Println("Server starting on " + cfg.Server.Addr)
+		serverErr <- server.ListenAndServe()
+	}()
+	if redirectServer != nil {
+		go func() {
+			log.Println("Redirecting HTTP to HTTPS on " + cfg.Server.RedirectAddr)
+			serverErr <- redirectServer.ListenAndServe()
+		}()
+	}
+	if metricsServer != nil {
+		go func() {
+			log.Println("Metrics on " + cfg.Server.MetricsAddr)
+			serverErr <- metricsServer.ListenAndServe()
+		}()
+	}
 
-	newMux := middleware.LoggingMiddleware(mux)
+	select {
+	case err := <-serverErr:
+		// The listener failed, e.g. the address is in use; still flush
+		// what was queued and save the links before exiting.
+		log.Printf("Server error: %v", err)
+	case <-ctx.Done():
+		log.Println("Shutting down")
+	}
+	stop()
 
-	log.Println("Server starting on :8080")
-	http.ListenAndServe(":8080", newMux)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	// Stop accepting connections and wait for in-flight requests, so
+	// every view they consumed is in storage and its event is queued.
+	if redirectServer != nil {
+		redirectServer.Shutdown(shutdownCtx)
+	}
+	if metricsServer != nil {
+		metricsServer.Shutdown(shutdownCtx)
+	}
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Server shutdown: %v", err)
+	}
+	stopWorkers()
+	workers.Wait()
+	if err := handlers.Events.Close(shutdownCtx); err != nil {
+		log.Printf("Dropping %d pending events: %v", handlers.Events.Pending(), err)
+	}
+	if err := handlers.Webhooks.Close(shutdownCtx); err != nil {
+		log.Printf("Giving up pending webhooks: %v", err)
+	}
+	if handlers.Notifications != nil {
+		if err := handlers.Notifications.Close(shutdownCtx); err != nil {
+			log.Printf("Giving up pending notifications: %v", err)
+		}
+	}
+	if cfg.Server.Snapshot != "" {
+		if err := storage.SaveSnapshot(cfg.Server.Snapshot); err != nil {
+			log.Printf("Snapshot error: %v", err)
+		} else {
+			log.Printf("Saved %d links to %s", storage.Len(), cfg.Server.Snapshot)
+		}
+	}
 }
 
 // Invoke-RestMethod -Method Post -Uri "http://127.0.0.1:8080/create" -Body @{secret="i love nika";maxviews=2}
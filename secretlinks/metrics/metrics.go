@@ -0,0 +1,110 @@
+package metrics
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "secretlinks"
+
+var (
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	HTTPDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route, method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	SecretsCreated = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "secrets_created_total",
+		Help:      "Secrets stored by /create.",
+	})
+
+	SecretsConsumed = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "secrets_consumed_total",
+		Help:      "Successful secret views.",
+	})
+
+	SecretsExpired = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "secrets_expired_total",
+		Help:      "Links removed because their time or views ran out.",
+	}, []string{"reason"})
+
+	SecretsRevoked = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "secrets_revoked_total",
+		Help:      "Links revoked by their creator before they ran out.",
+	})
+
+	SecretsDenied = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "secrets_denied_total",
+		Help:      "Views refused because the client address is outside the link's networks.",
+	})
+
+	EncryptionErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "encryption_errors_total",
+		Help:      "Failed encrypt and decrypt operations.",
+	}, []string{"operation"})
+
+	EventQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "event_queue_depth",
+		Help:      "Stats events waiting to be written to kafka.",
+	})
+
+	EventsPublished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_published_total",
+		Help:      "Stats events written to kafka by topic.",
+	}, []string{"topic"})
+
+	EventPublishFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "event_publish_failures_total",
+		Help:      "Stats events that were lost, by topic and reason (encode, queue_full, write).",
+	}, []string{"topic", "reason"})
+
+	WebhookDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_deliveries_total",
+		Help:      "Webhook delivery attempts by result (delivered, retry, failed, dropped).",
+	}, []string{"result"})
+)
+
+var (
+	activeLinks         atomic.Pointer[func() int]
+	registerActiveLinks sync.Once
+)
+
+// RegisterActiveLinks exports the number of stored links, read on every
+// scrape. The gauge is registered once; a later call replaces count.
+func RegisterActiveLinks(count func() int) {
+	activeLinks.Store(&count)
+	registerActiveLinks.Do(func() {
+		promauto.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "active_links",
+			Help:      "Links currently held in storage.",
+		}, func() float64 { return float64((*activeLinks.Load())()) })
+	})
+}
+
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,22 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterActiveLinksTwice(t *testing.T) {
+	RegisterActiveLinks(func() int { return 1 })
+	assert.NotPanics(t, func() { RegisterActiveLinks(func() int { return 2 }) })
+
+	expected := `
+# HELP secretlinks_active_links Links currently held in storage.
+# TYPE secretlinks_active_links gauge
+secretlinks_active_links 2
+`
+	assert.NoError(t, testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(expected), "secretlinks_active_links"))
+}
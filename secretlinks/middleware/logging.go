@@ -0,0 +1,130 @@
+package middleware
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the ID assigned by LoggingMiddleware, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Logger returns the default logger with the request ID of ctx attached.
+func Logger(ctx context.Context) *slog.Logger {
+	if id := RequestID(ctx); id != "" {
+		return slog.Default().With(slog.String("request_id", id))
+	}
+	return slog.Default()
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID accepts IDs from upstream proxies as long as they are
+// short and cannot break log lines.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// keyIDSecret keys the hashes of RedactKey. Keys are short and aliases are
+// words, so a plain hash could be reversed by trying them all. Unless
+// SetKeyIDSecret is called it is random, and ids only match within one
+// process.
+var keyIDSecret = func() []byte {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return b
+}()
+
+// SetKeyIDSecret derives the ids of RedactKey from secret, so they match
+// across processes configured alike. It must be called before serving.
+func SetKeyIDSecret(secret string) {
+	keyIDSecret = []byte(secret)
+}
+
+// RedactKey replaces a link key with a short keyed hash, stable enough to
+// follow one link through the logs without revealing it.
+func RedactKey(key string) string {
+	mac := hmac.New(sha256.New, keyIDSecret)
+	mac.Write([]byte(key))
+	return "hmac:" + hex.EncodeToString(mac.Sum(nil)[:6])
+}
+
+// LogPath returns the request path safe for logging. Paths served by an
+// exact mux route are kept; anything else may carry a link key and has
+// every segment after the matched prefix hashed.
+func LogPath(r *http.Request) string {
+	return logPath(r.URL.Path, r.Pattern)
+}
+
+func logPath(path, pattern string) string {
+	if i := strings.IndexByte(pattern, '/'); i > 0 {
+		// Drop the method or host part of patterns like "GET /links/".
+		pattern = pattern[i:]
+	}
+	if pattern != "" && pattern == path {
+		return path
+	}
+	if strings.Contains(pattern, "{") {
+		return redactWildcards(pattern, path)
+	}
+
+	prefix := "/"
+	if strings.HasSuffix(pattern, "/") && strings.HasPrefix(path, pattern) {
+		prefix = pattern
+	}
+	rest := strings.TrimPrefix(path, prefix)
+	if rest == "" {
+		return prefix
+	}
+	segments := strings.Split(rest, "/")
+	for i, segment := range segments {
+		if segment != "" {
+			segments[i] = RedactKey(segment)
+		}
+	}
+	return prefix + strings.Join(segments, "/")
+}
+
+// redactWildcards hashes the path segments matched by wildcards such as
+// {key} and keeps the literal ones.
+func redactWildcards(pattern, path string) string {
+	patternSegments := strings.Split(pattern, "/")
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if segment == "" {
+			continue
+		}
+		if i >= len(patternSegments) || strings.HasPrefix(patternSegments[i], "{") {
+			segments[i] = RedactKey(segment)
+		}
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"secretlinks/metrics"
+	"strconv"
+	"time"
+)
+
+// statusRecorder remembers the status code and body size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.size += n
+	return n, err
+}
+
+func (r *statusRecorder) Status() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// routeKey holds the *string the pattern matched by the ServeMux is shared
+// in between middleware.
+type routeKey struct{}
+
+// withRoute returns r carrying a place for the pattern the ServeMux will
+// match, and a function reporting the pattern once the request was served.
+// The ServeMux only sets the pattern on the request it is given, so the
+// innermost middleware using withRoute must wrap it directly; the ones
+// further out read what it found, even through middleware that derive
+// their own requests.
+func withRoute(r *http.Request) (*http.Request, func() string) {
+	route, ok := r.Context().Value(routeKey{}).(*string)
+	if !ok {
+		route = new(string)
+		r = r.WithContext(context.WithValue(r.Context(), routeKey{}, route))
+	}
+	return r, func() string {
+		if r.Pattern != "" {
+			*route = r.Pattern
+		}
+		return *route
+	}
+}
+
+// MetricsMiddleware counts requests and their latency. It must wrap the
+// ServeMux directly: the route label is the matched mux pattern, so link
+// keys never end up in metric labels.
+func MetricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		r, matched := withRoute(r)
+		next.ServeHTTP(rec, r)
+
+		route := matched()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(rec.Status())
+		metrics.HTTPRequests.WithLabelValues(route, r.Method, status).Inc()
+		metrics.HTTPDuration.WithLabelValues(route, r.Method, status).Observe(time.Since(start).Seconds())
+	})
+}
@@ -1,8 +1,16 @@
 package middleware
 
 import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"secretlinks/metrics"
+	"strings"
 	"testing"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -12,3 +20,318 @@ func TestEncryptionDecryption(t *testing.T) {
 	decrypted := DecryptText(encrypted)
 	assert.Equal(t, secret, decrypted)
 }
+
+func TestMetricsMiddlewareUsesRoutePattern(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Link expired", http.StatusGone)
+	})
+	handler := MetricsMiddleware(mux)
+
+	before := testutil.ToFloat64(metrics.HTTPRequests.WithLabelValues("/", "GET", "410"))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/AbCdEfGh", nil))
+
+	assert.Equal(t, http.StatusGone, w.Code)
+	assert.Equal(t, before+1, testutil.ToFloat64(metrics.HTTPRequests.WithLabelValues("/", "GET", "410")))
+}
+
+func captureLogs(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &buf
+}
+
+func TestLoggingMiddlewareRedactsKeys(t *testing.T) {
+	logs := captureLogs(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		assert.NotEmpty(t, RequestID(r.Context()))
+		w.Write([]byte("secret_msg"))
+	})
+
+	w := httptest.NewRecorder()
+	LoggingMiddleware(mux).ServeHTTP(w, httptest.NewRequest("GET", "/AbCdEfGh", nil))
+
+	var record map[string]any
+	assert.NoError(t, json.Unmarshal(logs.Bytes(), &record))
+	assert.NotContains(t, logs.String(), "AbCdEfGh")
+	assert.Equal(t, "/"+RedactKey("AbCdEfGh"), record["path"])
+	assert.Equal(t, float64(http.StatusOK), record["status"])
+	assert.Equal(t, float64(len("secret_msg")), record["size"])
+	assert.Equal(t, w.Header().Get(RequestIDHeader), record["request_id"])
+	assert.Len(t, w.Header().Get(RequestIDHeader), 32)
+}
+
+func TestLoggingMiddlewareKeepsStaticRoutes(t *testing.T) {
+	logs := captureLogs(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/create", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	})
+
+	req := httptest.NewRequest("GET", "/create", nil)
+	req.Header.Set(RequestIDHeader, "upstream-id-1")
+	w := httptest.NewRecorder()
+	LoggingMiddleware(mux).ServeHTTP(w, req)
+
+	var record map[string]any
+	assert.NoError(t, json.Unmarshal(logs.Bytes(), &record))
+	assert.Equal(t, "/create", record["path"])
+	assert.Equal(t, float64(http.StatusMethodNotAllowed), record["status"])
+	assert.Equal(t, "upstream-id-1", record["request_id"])
+	assert.Equal(t, "upstream-id-1", w.Header().Get(RequestIDHeader))
+}
+
+func TestLoggingMiddlewareRejectsMalformedRequestID(t *testing.T) {
+	captureLogs(t)
+	req := httptest.NewRequest("GET", "/create", nil)
+	req.Header.Set(RequestIDHeader, "bad id\nwith newline")
+	w := httptest.NewRecorder()
+	LoggingMiddleware(http.NotFoundHandler()).ServeHTTP(w, req)
+
+	assert.NotEqual(t, "bad id\nwith newline", w.Header().Get(RequestIDHeader))
+	assert.NotEmpty(t, w.Header().Get(RequestIDHeader))
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.10", "::1"})
+	assert.NoError(t, err)
+
+	assert.True(t, proxies.Contains("10.1.2.3:4567"))
+	assert.True(t, proxies.Contains("192.168.1.10:80"))
+	assert.True(t, proxies.Contains("[::1]:80"))
+	assert.True(t, proxies.Contains("[::ffff:10.0.0.1]:80"))
+	assert.False(t, proxies.Contains("192.168.1.11:80"))
+	assert.False(t, proxies.Contains("not an address"))
+
+	_, err = ParseTrustedProxies([]string{"10.0.0.0/33"})
+	assert.Error(t, err)
+}
+
+func TestSchemeFromTrustedProxyOnly(t *testing.T) {
+	proxies, _ := ParseTrustedProxies([]string{"10.0.0.0/8"})
+	var scheme string
+	handler := ProxyMiddleware(proxies, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheme = Scheme(r)
+	}))
+
+	tests := []struct {
+		remote, proto, want string
+	}{
+		{"10.0.0.1:1234", "https", "https"},
+		{"10.0.0.1:1234", "HTTPS, http", "https"},
+		{"10.0.0.1:1234", "gopher", "http"},
+		{"10.0.0.1:1234", "", "http"},
+		{"203.0.113.7:1234", "https", "http"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/create", nil)
+		req.RemoteAddr = tt.remote
+		if tt.proto != "" {
+			req.Header.Set("X-Forwarded-Proto", tt.proto)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		assert.Equal(t, tt.want, scheme, "remote %s, X-Forwarded-Proto %q", tt.remote, tt.proto)
+	}
+}
+
+func TestSchemeFromTLSConnection(t *testing.T) {
+	req := httptest.NewRequest("GET", "https://example.com/create", nil)
+	assert.Equal(t, "https", Scheme(req))
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	tests := []struct {
+		tlsAddr, host, want string
+	}{
+		{":443", "example.com", "https://example.com/AbCdEfGh?x=1"},
+		{":443", "example.com:80", "https://example.com/AbCdEfGh?x=1"},
+		{":8443", "example.com:8080", "https://example.com:8443/AbCdEfGh?x=1"},
+		{":8443", "[::1]:8080", "https://[::1]:8443/AbCdEfGh?x=1"},
+		{":443", "[::1]", "https://[::1]/AbCdEfGh?x=1"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/AbCdEfGh?x=1", nil)
+		req.Host = tt.host
+		w := httptest.NewRecorder()
+		RedirectToHTTPS(tt.tlsAddr).ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusPermanentRedirect, w.Code)
+		assert.Equal(t, tt.want, w.Header().Get("Location"))
+	}
+}
+
+func TestClientIPAndHostFromTrustedProxies(t *testing.T) {
+	proxies, _ := ParseTrustedProxies([]string{"10.0.0.0/8"})
+	var clientIP, host string
+	handler := ProxyMiddleware(proxies, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientIP, host = ClientIP(r), Host(r)
+	}))
+
+	tests := []struct {
+		name, remote, forwardedFor, forwardedHost string
+		wantIP, wantHost                          string
+	}{
+		{"direct client", "203.0.113.7:1234", "198.51.100.1", "evil.example", "203.0.113.7", "links.example.com"},
+		{"one proxy", "10.0.0.1:1234", "198.51.100.1", "public.example", "198.51.100.1", "public.example"},
+		{"proxy chain", "10.0.0.1:1234", "198.51.100.1, 10.0.0.2", "", "198.51.100.1", "links.example.com"},
+		{"spoofed prefix", "10.0.0.1:1234", "1.2.3.4, 198.51.100.1", "", "198.51.100.1", "links.example.com"},
+		{"only proxies", "10.0.0.1:1234", "10.0.0.3", "", "10.0.0.3", "links.example.com"},
+		{"garbage", "10.0.0.1:1234", "unknown", "evil.example/path", "10.0.0.1", "links.example.com"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Host = "links.example.com"
+		req.RemoteAddr = tt.remote
+		req.Header.Set("X-Forwarded-For", tt.forwardedFor)
+		if tt.forwardedHost != "" {
+			req.Header.Set("X-Forwarded-Host", tt.forwardedHost)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		assert.Equal(t, tt.wantIP, clientIP, tt.name)
+		assert.Equal(t, tt.wantHost, host, tt.name)
+	}
+}
+
+func TestSecurityHeadersMiddlewareStrict(t *testing.T) {
+	handler := SecurityHeadersMiddleware(StrictHeaders, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secret"))
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/AbCdEfGh", nil))
+
+	h := w.Header()
+	assert.Equal(t, "no-store", h.Get("Cache-Control"))
+	assert.Equal(t, "no-cache", h.Get("Pragma"))
+	assert.Contains(t, h.Get("Content-Security-Policy"), "default-src 'none'")
+	assert.Contains(t, h.Get("Content-Security-Policy"), "frame-ancestors 'none'")
+	assert.Equal(t, "no-referrer", h.Get("Referrer-Policy"))
+	assert.Equal(t, "DENY", h.Get("X-Frame-Options"))
+	assert.Equal(t, "nosniff", h.Get("X-Content-Type-Options"))
+	assert.Empty(t, h.Get("Strict-Transport-Security"), "HSTS must not be sent over plain HTTP")
+}
+
+func TestSecurityHeadersMiddlewareHSTSOnTLS(t *testing.T) {
+	handler := SecurityHeadersMiddleware(StrictHeaders, http.NotFoundHandler())
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "https://example.com/AbCdEfGh", nil))
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "max-age=31536000", w.Header().Get("Strict-Transport-Security"))
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"), "error responses keep the headers")
+}
+
+func TestSecurityHeadersMiddlewarePerRoute(t *testing.T) {
+	relaxed := StrictHeaders
+	relaxed.NoStore = false
+	relaxed.ContentSecurityPolicy = ""
+	relaxed.HSTSMaxAge = 0
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", SecurityHeadersMiddleware(relaxed, http.NotFoundHandler()))
+	mux.Handle("/", SecurityHeadersMiddleware(StrictHeaders, http.NotFoundHandler()))
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "https://example.com/metrics", nil))
+	assert.Empty(t, w.Header().Get("Cache-Control"))
+	assert.Empty(t, w.Header().Get("Content-Security-Policy"))
+	assert.Empty(t, w.Header().Get("Strict-Transport-Security"))
+	assert.Equal(t, "DENY", w.Header().Get("X-Frame-Options"))
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "https://example.com/AbCdEfGh", nil))
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+}
+
+func TestLoggingMiddlewareRedactsWildcards(t *testing.T) {
+	logs := captureLogs(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /secrets/s/{key}", func(w http.ResponseWriter, r *http.Request) {})
+
+	LoggingMiddleware(mux).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/secrets/s/AbCdEfGh", nil))
+
+	var record map[string]any
+	assert.NoError(t, json.Unmarshal(logs.Bytes(), &record))
+	assert.Equal(t, "/secrets/s/"+RedactKey("AbCdEfGh"), record["path"])
+}
+
+func TestRouteReachesOuterMiddleware(t *testing.T) {
+	logs := captureLogs(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/links/{key}", func(w http.ResponseWriter, r *http.Request) {})
+	// As in main: only MetricsMiddleware sees the request the mux matched.
+	handler := LoggingMiddleware(AuthMiddleware(NewAPIKeys([]string{"sk-test"}), MetricsMiddleware(mux)))
+
+	req := httptest.NewRequest("GET", "/api/links/AbCdEfGh", nil)
+	req.Header.Set("Authorization", "Bearer sk-test")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	var record map[string]any
+	assert.NoError(t, json.Unmarshal(logs.Bytes(), &record))
+	assert.Equal(t, "/api/links/"+RedactKey("AbCdEfGh"), record["path"])
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	keys := NewAPIKeys([]string{"sk-first", "sk-second"})
+	var authenticated, called bool
+	handler := AuthMiddleware(keys, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		authenticated = Authenticated(r.Context())
+	}))
+
+	tests := []struct {
+		header        string
+		wantStatus    int
+		authenticated bool
+	}{
+		{"", http.StatusOK, false},
+		{"Bearer sk-second", http.StatusOK, true},
+		{"bearer sk-first", http.StatusOK, true},
+		{"Bearer sk-third", http.StatusUnauthorized, false},
+		{"Basic c2stZmlyc3Q=", http.StatusUnauthorized, false},
+	}
+	for _, tt := range tests {
+		called, authenticated = false, false
+		req := httptest.NewRequest("POST", "/create", nil)
+		if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		assert.Equal(t, tt.wantStatus, w.Code, tt.header)
+		assert.Equal(t, tt.wantStatus == http.StatusOK, called, tt.header)
+		assert.Equal(t, tt.authenticated, authenticated, tt.header)
+	}
+}
+
+func TestAuthMiddlewareWithoutKeys(t *testing.T) {
+	var authenticated bool
+	handler := AuthMiddleware(NewAPIKeys(nil), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authenticated = Authenticated(r.Context())
+	}))
+	req := httptest.NewRequest("POST", "/create", nil)
+	req.Header.Set("Authorization", "Bearer anything")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.False(t, authenticated)
+}
+func TestRedactKeyIsKeyed(t *testing.T) {
+	defer SetKeyIDSecret(string(keyIDSecret))
+
+	SetKeyIDSecret("first secret")
+	first := RedactKey("AbCdEfGh")
+	assert.Equal(t, first, RedactKey("AbCdEfGh"), "stable within a process")
+	assert.True(t, strings.HasPrefix(first, "hmac:"))
+	assert.NotContains(t, first, "AbCdEfGh")
+
+	SetKeyIDSecret("second secret")
+	assert.NotEqual(t, first, RedactKey("AbCdEfGh"), "an id cannot be recomputed without the secret")
+}
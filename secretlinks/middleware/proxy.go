@@ -0,0 +1,186 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+)
+
+// TrustedProxies are the networks of reverse proxies whose X-Forwarded-*
+// headers are believed. Headers from anybody else are ignored, since any
+// client can send them.
+type TrustedProxies []netip.Prefix
+
+// ParseTrustedProxies accepts CIDR ranges and single addresses.
+func ParseTrustedProxies(entries []string) (TrustedProxies, error) {
+	var proxies TrustedProxies
+	for _, entry := range entries {
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("trusted proxy %q: %w", entry, err)
+			}
+			proxies = append(proxies, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q: %w", entry, err)
+		}
+		proxies = append(proxies, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return proxies, nil
+}
+
+// Contains reports whether remoteAddr, an address with or without a port
+// as found in http.Request.RemoteAddr, belongs to a trusted proxy.
+func (t TrustedProxies) Contains(remoteAddr string) bool {
+	host := remoteAddr
+	if h, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		host = h
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range t {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+type forwardedKey struct{}
+
+// forwarded is what the client sent as seen past the trusted proxies.
+type forwarded struct {
+	scheme   string
+	host     string
+	clientIP string
+}
+
+// ProxyMiddleware works out the client address, and the scheme and host
+// the client used, for ClientIP, Scheme and Host to return. X-Forwarded-*
+// headers are only honored when the request comes from a trusted proxy.
+func ProxyMiddleware(trusted TrustedProxies, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fwd := forwarded{clientIP: remoteIP(r.RemoteAddr)}
+		if trusted.Contains(r.RemoteAddr) {
+			fwd.clientIP = forwardedFor(trusted, r.Header.Values("X-Forwarded-For"), fwd.clientIP)
+			proto, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Proto"), ",")
+			switch proto = strings.ToLower(strings.TrimSpace(proto)); proto {
+			case "http", "https":
+				fwd.scheme = proto
+			}
+			host, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Host"), ",")
+			if host = strings.TrimSpace(host); validHost(host) {
+				fwd.host = host
+			}
+		}
+		req := r.WithContext(context.WithValue(r.Context(), forwardedKey{}, fwd))
+		next.ServeHTTP(w, req)
+	})
+}
+
+// forwardedFor walks X-Forwarded-For from the nearest hop backwards and
+// returns the first address that is not a trusted proxy. Earlier entries
+// were written by the client itself and are never believed.
+func forwardedFor(trusted TrustedProxies, headers []string, remote string) string {
+	var hops []string
+	for _, header := range headers {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	client := remote
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		client = addr.Unmap().String()
+		if !trusted.Contains(client) {
+			break
+		}
+	}
+	return client
+}
+
+func remoteIP(remoteAddr string) string {
+	host := remoteAddr
+	if h, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		host = h
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return addr.Unmap().String()
+	}
+	return host
+}
+
+// validHost accepts a host with an optional port and nothing else, so a
+// forwarded header cannot smuggle a path or credentials into links.
+func validHost(host string) bool {
+	if host == "" {
+		return false
+	}
+	u, err := url.Parse("//" + host)
+	return err == nil && u.Host == host && u.User == nil && u.Path == ""
+}
+
+func forwardedFrom(r *http.Request) forwarded {
+	fwd, _ := r.Context().Value(forwardedKey{}).(forwarded)
+	return fwd
+}
+
+// Scheme returns "https" or "http" as the client sees the server: the
+// scheme forwarded by a trusted proxy, otherwise whether the connection
+// itself uses TLS.
+func Scheme(r *http.Request) string {
+	if fwd := forwardedFrom(r); fwd.scheme != "" {
+		return fwd.scheme
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// Host returns the host the client asked for: the one forwarded by a
+// trusted proxy, otherwise the Host header.
+func Host(r *http.Request) string {
+	if fwd := forwardedFrom(r); fwd.host != "" {
+		return fwd.host
+	}
+	return r.Host
+}
+
+// ClientIP returns the address of the client, looking past trusted
+// proxies.
+func ClientIP(r *http.Request) string {
+	if fwd := forwardedFrom(r); fwd.clientIP != "" {
+		return fwd.clientIP
+	}
+	return remoteIP(r.RemoteAddr)
+}
+
+// RedirectToHTTPS answers every request with a permanent redirect to the
+// same URL on the TLS listener at tlsAddr.
+func RedirectToHTTPS(tlsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(tlsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := strings.TrimSuffix(strings.TrimPrefix(r.Host, "["), "]")
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
@@ -1,18 +1,46 @@
 package middleware
 
 import (
-	"log"
+	"log/slog"
 	"net/http"
+	"secretlinks/metrics"
 	"time"
 
 	"github.com/boseji/auth/aesgcm"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// LoggingMiddleware writes one structured record per request through
+// slog.Default. The request gets an ID, taken from a well-formed
+// X-Request-ID header or generated, which is stored in the context and
+// echoed in the response. Paths that carry a link key are logged with the
+// key hashed, using the route found as in TracingMiddleware.
 func LoggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		next.ServeHTTP(w, r)
-		log.Printf("%s %s %v", r.Method, r.URL.Path, time.Since(start))
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		req, matched := withRoute(r.WithContext(WithRequestID(r.Context(), id)))
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, req)
+
+		attrs := []slog.Attr{
+			slog.String("request_id", id),
+			slog.String("method", req.Method),
+			slog.String("path", logPath(req.URL.Path, matched())),
+			slog.String("client_ip", ClientIP(req)),
+			slog.Int("status", rec.Status()),
+			slog.Int("size", rec.size),
+			slog.Duration("duration", time.Since(start)),
+		}
+		if sc := trace.SpanContextFromContext(req.Context()); sc.IsValid() {
+			attrs = append(attrs, slog.String("trace_id", sc.TraceID().String()))
+		}
+		slog.LogAttrs(req.Context(), slog.LevelInfo, "request", attrs...)
 	})
 }
 
@@ -24,6 +52,7 @@ func EncryptText(text string) string {
 	iNonce := make([]byte, aesgcm.NonceSize)
 	ciphertext, _, err := aesgcm.Encrypt([]byte(text), keyIs(), iNonce)
 	if err != nil {
+		metrics.EncryptionErrors.WithLabelValues("encrypt").Inc()
 		panic(err)
 	}
 	return string(ciphertext)
@@ -33,6 +62,7 @@ func DecryptText(ciphertext string) string {
 	iNonce := make([]byte, aesgcm.NonceSize)
 	text, err := aesgcm.Decrypt([]byte(ciphertext), iNonce, keyIs())
 	if err != nil {
+		metrics.EncryptionErrors.WithLabelValues("decrypt").Inc()
 		panic(err)
 	}
 	return string(text)
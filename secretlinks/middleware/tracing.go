@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracingMiddleware starts a server span per request, continuing a trace
+// passed in the traceparent header. The span is named after the matched
+// route, never the path, so link keys stay out of traces; the route is
+// found by MetricsMiddleware around the ServeMux, or by this middleware
+// when it wraps the ServeMux itself.
+func TracingMiddleware(next http.Handler) http.Handler {
+	tracer := otel.Tracer("secretlinks/middleware")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method, trace.WithSpanKind(trace.SpanKindServer))
+		defer span.End()
+
+		rec := &statusRecorder{ResponseWriter: w}
+		req, matched := withRoute(r.WithContext(ctx))
+		next.ServeHTTP(rec, req)
+
+		route := matched()
+		if route == "" {
+			route = "unmatched"
+		}
+		span.SetName(r.Method + " " + route)
+		span.SetAttributes(
+			attribute.String("http.request.method", r.Method),
+			attribute.String("http.route", route),
+			attribute.Int("http.response.status_code", rec.Status()),
+		)
+		if rec.Status() >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.Status()))
+		}
+	})
+}
@@ -0,0 +1,38 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileDrop writes every notification as a .eml file into Dir instead of
+// sending it, for tests and local development.
+type FileDrop struct {
+	Dir  string
+	From string
+}
+
+func (f *FileDrop) Notify(ctx context.Context, n Notification) error {
+	if err := os.MkdirAll(f.Dir, 0o700); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), n.Event)
+	// Written under a temporary name, so readers never see half a message.
+	tmp, err := os.CreateTemp(f.Dir, ".notification-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(Message(f.From, n)); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(f.Dir, name))
+}
@@ -0,0 +1,165 @@
+// Package notify emails link creators when their secret is viewed or
+// expires unread, for those who do not run a webhook receiver.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/mail"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Events a creator is notified of.
+const (
+	EventViewed  = "viewed"
+	EventExpired = "expired" // expired without ever being viewed
+)
+
+// Notification is one message to a link creator. Mail passes through
+// relays and archives, so it never carries the secret, the link or its
+// key, only the key's id as in the server logs.
+type Notification struct {
+	To       string
+	Event    string
+	ID       string
+	At       time.Time
+	Views    int
+	MaxViews int
+}
+
+// Notifier delivers notifications.
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// ParseAddress checks a creator's address and returns it without display
+// name, as the notifiers expect it.
+func ParseAddress(address string) (string, error) {
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return "", errors.New("invalid email address")
+	}
+	return parsed.Address, nil
+}
+
+// Message renders n as an RFC 5322 message from from.
+func Message(from string, n Notification) []byte {
+	var subject, body string
+	switch n.Event {
+	case EventViewed:
+		subject = fmt.Sprintf("Your secret %s was viewed", n.ID)
+		body = fmt.Sprintf("Your secret %s was viewed at %s (view %d of %d).\r\n", n.ID, n.At.UTC().Format(time.RFC1123), n.Views, n.MaxViews)
+		if n.Views >= n.MaxViews {
+			body += "It cannot be viewed again.\r\n"
+		}
+	case EventExpired:
+		subject = fmt.Sprintf("Your secret %s expired unread", n.ID)
+		body = fmt.Sprintf("Your secret %s expired at %s without being viewed.\r\n", n.ID, n.At.UTC().Format(time.RFC1123))
+	default:
+		subject = fmt.Sprintf("Your secret %s: %s", n.ID, n.Event)
+	}
+	body += "\r\nThis is an automatic message from secretlinks.\r\n"
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", n.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%s@%s>\r\n", messageID(), domain(from))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Auto-Submitted: auto-generated\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(body)
+	return b.Bytes()
+}
+
+func messageID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func domain(address string) string {
+	if at := strings.LastIndexByte(address, '@'); at >= 0 {
+		return strings.TrimSuffix(address[at+1:], ">")
+	}
+	return "secretlinks"
+}
+
+// Queue sends notifications in the background, so a slow mail server does
+// not hold up HTTP requests. Failed notifications are logged, not retried.
+type Queue struct {
+	notifier Notifier
+	timeout  time.Duration
+	queue    chan Notification
+	done     chan struct{}
+	mu       sync.RWMutex
+	closed   bool
+}
+
+// NewQueue sends through notifier, giving each notification timeout.
+func NewQueue(notifier Notifier, size int, timeout time.Duration) *Queue {
+	q := &Queue{
+		notifier: notifier,
+		timeout:  timeout,
+		queue:    make(chan Notification, size),
+		done:     make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+// Send queues n. It never blocks: when the queue is full n is dropped and
+// false is returned.
+func (q *Queue) Send(n Notification) bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return false
+	}
+	select {
+	case q.queue <- n:
+		return true
+	default:
+		slog.Warn("notification queue full, dropping notification", "event", n.Event)
+		return false
+	}
+}
+
+// Close stops accepting notifications and sends the queued ones. It gives
+// up when ctx is done.
+func (q *Queue) Close(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.queue)
+	}
+	q.mu.Unlock()
+
+	select {
+	case <-q.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (q *Queue) run() {
+	defer close(q.done)
+	for n := range q.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), q.timeout)
+		if err := q.notifier.Notify(ctx, n); err != nil {
+			slog.Error("notification failed", "event", n.Event, "err", err)
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,162 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var viewed = Notification{To: "alice@example.com", Event: EventViewed, ID: "hmac:0a1b2c3d4e5f", At: time.Date(2025, 7, 15, 12, 0, 0, 0, time.UTC), Views: 1, MaxViews: 1}
+
+func TestMessage(t *testing.T) {
+	msg, err := mail.ReadMessage(strings.NewReader(string(Message("links@example.com", viewed))))
+	require.NoError(t, err)
+	assert.Equal(t, "alice@example.com", msg.Header.Get("To"))
+	assert.Equal(t, "Your secret hmac:0a1b2c3d4e5f was viewed", msg.Header.Get("Subject"))
+	assert.Contains(t, msg.Header.Get("Message-ID"), "@example.com>")
+	body := new(strings.Builder)
+	bufio.NewReader(msg.Body).WriteTo(body)
+	assert.Contains(t, body.String(), "view 1 of 1")
+	assert.Contains(t, body.String(), "cannot be viewed again")
+}
+
+func TestParseAddress(t *testing.T) {
+	address, err := ParseAddress("Alice <alice@example.com>")
+	assert.NoError(t, err)
+	assert.Equal(t, "alice@example.com", address)
+	for _, bad := range []string{"", "alice", "alice@example.com\r\nBcc: eve@example.com"} {
+		_, err := ParseAddress(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestFileDrop(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	drop := &FileDrop{Dir: dir, From: "links@example.com"}
+	require.NoError(t, drop.Notify(context.Background(), viewed))
+	expired := viewed
+	expired.Event = EventExpired
+	require.NoError(t, drop.Notify(context.Background(), expired))
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	data, err := os.ReadFile(files[1])
+	require.NoError(t, err)
+	msg, err := mail.ReadMessage(strings.NewReader(string(data)))
+	require.NoError(t, err)
+	assert.Equal(t, "Your secret hmac:0a1b2c3d4e5f expired unread", msg.Header.Get("Subject"))
+}
+
+// fakeSMTP accepts one session and records the envelope and message.
+type fakeSMTP struct {
+	addr string
+	mu   sync.Mutex
+	from string
+	to   string
+	data string
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	server := &fakeSMTP{addr: ln.Addr().String()}
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		tp := textproto.NewConn(conn)
+		tp.PrintfLine("220 fake ESMTP")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			verb, arg, _ := strings.Cut(line, " ")
+			switch strings.ToUpper(verb) {
+			case "EHLO", "HELO":
+				tp.PrintfLine("250 fake")
+			case "MAIL":
+				server.mu.Lock()
+				server.from = arg
+				server.mu.Unlock()
+				tp.PrintfLine("250 OK")
+			case "RCPT":
+				server.mu.Lock()
+				server.to = arg
+				server.mu.Unlock()
+				tp.PrintfLine("250 OK")
+			case "DATA":
+				tp.PrintfLine("354 go ahead")
+				data, err := tp.ReadDotBytes()
+				if err != nil {
+					return
+				}
+				server.mu.Lock()
+				server.data = string(data)
+				server.mu.Unlock()
+				tp.PrintfLine("250 queued")
+			case "QUIT":
+				tp.PrintfLine("221 bye")
+				return
+			default:
+				tp.PrintfLine("502 not implemented")
+			}
+		}
+	}()
+	return server
+}
+
+func TestSMTP(t *testing.T) {
+	server := newFakeSMTP(t)
+	notifier := &SMTP{Addr: server.addr, From: "links@example.com"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, notifier.Notify(ctx, viewed))
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	assert.Equal(t, "FROM:<links@example.com>", server.from)
+	assert.Equal(t, "TO:<alice@example.com>", server.to)
+	assert.Contains(t, server.data, "Subject: Your secret hmac:0a1b2c3d4e5f was viewed")
+}
+
+type recordingNotifier struct {
+	mu   sync.Mutex
+	sent []Notification
+}
+
+func (r *recordingNotifier) Notify(ctx context.Context, n Notification) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sent = append(r.sent, n)
+	return nil
+}
+
+func TestQueue(t *testing.T) {
+	notifier := &recordingNotifier{}
+	q := NewQueue(notifier, 10, time.Second)
+	assert.True(t, q.Send(viewed))
+	assert.True(t, q.Send(viewed))
+	require.NoError(t, q.Close(context.Background()))
+	assert.False(t, q.Send(viewed), "closed queues drop notifications")
+
+	notifier.mu.Lock()
+	defer notifier.mu.Unlock()
+	assert.Len(t, notifier.sent, 2)
+}
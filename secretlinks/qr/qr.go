@@ -0,0 +1,91 @@
+// Package qr renders links as QR codes. Everything happens in memory and
+// nothing is kept after the call returns.
+package qr
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// Formats accepted by Encode.
+const (
+	PNG = "png"
+	SVG = "svg"
+)
+
+// PNGSize is the width and height of PNG images in pixels.
+const PNGSize = 320
+
+// ContentType returns the media type of format.
+func ContentType(format string) string {
+	if format == SVG {
+		return "image/svg+xml"
+	}
+	return "image/png"
+}
+
+// Encode renders text as a QR code image in format.
+func Encode(text, format string) ([]byte, error) {
+	code, err := qrcode.New(text, qrcode.Medium)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case PNG:
+		return code.PNG(PNGSize)
+	case SVG:
+		return svg(code.Bitmap()), nil
+	}
+	return nil, fmt.Errorf("unknown QR code format %q", format)
+}
+
+// svg draws one path with a unit square per dark module, scaled by the
+// viewer.
+func svg(bitmap [][]bool) []byte {
+	var b bytes.Buffer
+	n := len(bitmap)
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, n, n)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, n, n)
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&b, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+	b.WriteString(`"/></svg>`)
+	return b.Bytes()
+}
+
+// Terminal renders text as a QR code of block characters, two modules
+// per character cell. Light modules are drawn, so the code reads right on
+// terminals with a dark background.
+func Terminal(text string) (string, error) {
+	code, err := qrcode.New(text, qrcode.Medium)
+	if err != nil {
+		return "", err
+	}
+	bitmap := code.Bitmap()
+	var b strings.Builder
+	for y := 0; y < len(bitmap); y += 2 {
+		for x := range bitmap[y] {
+			top := bitmap[y][x]
+			bottom := y+1 < len(bitmap) && bitmap[y+1][x]
+			switch {
+			case top && bottom:
+				b.WriteRune(' ')
+			case top:
+				b.WriteRune('▄')
+			case bottom:
+				b.WriteRune('▀')
+			default:
+				b.WriteRune('█')
+			}
+		}
+		b.WriteByte('\n')
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,60 @@
+package qr
+
+import (
+	"bytes"
+	"encoding/xml"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const link = "https://links.example.com/secrets/AbCdEfGh#c2VjcmV0LWtleS1mb3ItdGhlLWxpbms"
+
+func TestEncodePNG(t *testing.T) {
+	data, err := Encode(link, PNG)
+	require.NoError(t, err)
+
+	img, err := png.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, PNGSize, img.Bounds().Dx())
+	assert.Equal(t, PNGSize, img.Bounds().Dy())
+	assert.Equal(t, "image/png", ContentType(PNG))
+}
+
+func TestEncodeSVG(t *testing.T) {
+	data, err := Encode(link, SVG)
+	require.NoError(t, err)
+
+	var doc struct {
+		XMLName xml.Name
+		ViewBox string `xml:"viewBox,attr"`
+		Path    struct {
+			D string `xml:"d,attr"`
+		} `xml:"path"`
+	}
+	require.NoError(t, xml.Unmarshal(data, &doc))
+	assert.Equal(t, "svg", doc.XMLName.Local)
+	assert.NotEmpty(t, doc.ViewBox)
+	assert.Contains(t, doc.Path.D, "h1v1h-1z")
+	assert.NotContains(t, string(data), "AbCdEfGh", "the link is only in the modules")
+	assert.Equal(t, "image/svg+xml", ContentType(SVG))
+}
+
+func TestEncodeRejectsUnknownFormat(t *testing.T) {
+	_, err := Encode(link, "gif")
+	assert.Error(t, err)
+}
+
+func TestTerminal(t *testing.T) {
+	out, err := Terminal(link)
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	width := len([]rune(lines[0]))
+	assert.Equal(t, (width+1)/2, len(lines), "two rows of modules per line")
+	// The quiet zone around the code is light.
+	assert.Equal(t, strings.Repeat("█", width), lines[0])
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	appconfig "secretlinks/config"
+	"secretlinks/middleware"
+	"strings"
+
+	"github.com/segmentio/kafka-go"
+)
+
+const deadLetterUsage = `usage: stats deadletter <command> [flags]
+
+commands:
+  list      print dead-lettered messages
+  replay    send dead-lettered messages back to their topics
+`
+
+func runDeadLetterCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, deadLetterUsage)
+		return 2
+	}
+
+	defaults := appconfig.Default()
+	flags := flag.NewFlagSet("deadletter "+args[0], flag.ContinueOnError)
+	path := flags.String("file", defaults.Stats.DeadLetter, "dead-letter file")
+	brokers := flags.String("brokers", strings.Join(defaults.Kafka.Brokers, ","), "comma-separated kafka brokers (replay)")
+	index := flags.Int("n", -1, "replay only the message with this number (replay)")
+	keyIDSecret := flags.String("key-id-secret", os.Getenv("SECRETLINKS_KEY_ID_SECRET"), "secret of the key ids, to match them with the logs (list)")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if *keyIDSecret != "" {
+		middleware.SetKeyIDSecret(*keyIDSecret)
+	}
+	deadLetters := NewDeadLetterFile(*path)
+
+	switch args[0] {
+	case "list":
+		letters, err := deadLetters.List()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		for i, dl := range letters {
+			fmt.Printf("#%d %s [%d:%d] key=%s attempts=%d failed=%s\n    reason: %s\n    value: %q\n",
+				i, dl.Topic, dl.Partition, dl.Offset, middleware.RedactKey(string(dl.Key)), dl.Attempts,
+				dl.FailedAt.Format("2006-01-02 15:04:05"), dl.Reason, redactValue(dl))
+		}
+		fmt.Printf("%d dead-lettered message(s)\n", len(letters))
+		return 0
+
+	case "replay":
+		writer := &kafka.Writer{
+			Addr:     kafka.TCP(strings.Split(*brokers, ",")...),
+			Balancer: &kafka.Hash{},
+		}
+		defer writer.Close()
+
+		var selected func(int, DeadLetter) bool
+		if *index >= 0 {
+			selected = func(i int, _ DeadLetter) bool { return i == *index }
+		}
+		replayed, err := deadLetters.Replay(context.Background(), writer, selected)
+		fmt.Printf("Replayed %d message(s)\n", replayed)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+
+	fmt.Fprint(os.Stderr, deadLetterUsage)
+	return 2
+}
+
+// redactValue returns the message value with the link key, which is also
+// the message key, replaced by its id.
+func redactValue(dl DeadLetter) []byte {
+	if len(dl.Key) == 0 {
+		return dl.Value
+	}
+	return bytes.ReplaceAll(dl.Value, dl.Key, []byte(middleware.RedactKey(string(dl.Key))))
+}
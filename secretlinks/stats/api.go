@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"secretlinks/middleware"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 1000
+)
+
+type LinkListResponse struct {
+	Total  int         `json:"total"`
+	Offset int         `json:"offset"`
+	Limit  int         `json:"limit"`
+	Items  []StatsItem `json:"items"`
+}
+
+type AggregatesResponse struct {
+	Links          int        `json:"links"`
+	VisitedLinks   int        `json:"visitedlinks"`
+	UnvisitedLinks int        `json:"unvisitedlinks"`
+	Visits         int        `json:"visits"`
+	FirstCreated   *time.Time `json:"firstcreated,omitempty"`
+	LastCreated    *time.Time `json:"lastcreated,omitempty"`
+	LastVisit      *time.Time `json:"lastvisit,omitempty"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// NewAPIHandler serves the statistics as JSON. A link key is the secret
+// URL itself, so links are only ever shown and looked up by their
+// middleware.RedactKey id:
+//
+//	GET /links/{id}    creation and visit history of one link
+//	GET /links/{id}/lifecycle
+//	                   joined lifecycle of one link
+//	GET /links         list with ?offset, ?limit, ?created_after,
+//	                   ?created_before (RFC 3339) and ?visited=true|false
+//	GET /aggregates    totals over all links
+//	GET /lifecycle     lifecycle report over links created between
+//	                   ?created_after and ?created_before
+//	GET /rollups       event counters per ?resolution=minute|hour|day
+//	                   between ?from and ?to (RFC 3339)
+func NewAPIHandler(s *StatsStorage) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /links/{id}", linkHandler(s))
+	mux.HandleFunc("GET /links/{id}/lifecycle", linkLifecycleHandler(s))
+	mux.HandleFunc("GET /links", listLinksHandler(s))
+	mux.HandleFunc("GET /lifecycle", lifecycleReportHandler(s))
+	mux.HandleFunc("GET /aggregates", aggregatesHandler(s))
+	mux.HandleFunc("GET /rollups", rollupsHandler(s))
+	return mux
+}
+
+// redacted returns item with its key replaced by the key's id.
+func redacted(item StatsItem) StatsItem {
+	item.LinkKey = middleware.RedactKey(item.LinkKey)
+	return item
+}
+
+// findItem returns the item whose key has the given id, already redacted.
+func findItem(s *StatsStorage, id string) (StatsItem, bool) {
+	item, exists := s.GetItemByID(id)
+	return redacted(item), exists
+}
+
+func linkHandler(s *StatsStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		item, exists := findItem(s, r.PathValue("id"))
+		if !exists {
+			writeError(w, "Link not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, item)
+	}
+}
+
+func listLinksHandler(s *StatsStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		offset, err := intParam(query.Get("offset"), 0)
+		if err != nil || offset < 0 {
+			writeError(w, "Expected non-negative int 'offset'", http.StatusBadRequest)
+			return
+		}
+		limit, err := intParam(query.Get("limit"), defaultPageLimit)
+		if err != nil || limit <= 0 {
+			writeError(w, "Expected positive int 'limit'", http.StatusBadRequest)
+			return
+		}
+		if limit > maxPageLimit {
+			limit = maxPageLimit
+		}
+		createdAfter, err := timeParam(query.Get("created_after"))
+		if err != nil {
+			writeError(w, "Expected RFC 3339 time 'created_after'", http.StatusBadRequest)
+			return
+		}
+		createdBefore, err := timeParam(query.Get("created_before"))
+		if err != nil {
+			writeError(w, "Expected RFC 3339 time 'created_before'", http.StatusBadRequest)
+			return
+		}
+		var visited *bool
+		if v := query.Get("visited"); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				writeError(w, "Expected bool 'visited'", http.StatusBadRequest)
+				return
+			}
+			visited = &b
+		}
+
+		var matched []StatsItem
+		for _, item := range s.ListItems() {
+			if !createdAfter.IsZero() && item.CreateTime.Before(createdAfter) {
+				continue
+			}
+			if !createdBefore.IsZero() && !item.CreateTime.Before(createdBefore) {
+				continue
+			}
+			if visited != nil && (len(item.VisitTime) > 0) != *visited {
+				continue
+			}
+			matched = append(matched, redacted(item))
+		}
+
+		page := []StatsItem{}
+		if offset < len(matched) {
+			page = matched[offset:min(offset+limit, len(matched))]
+		}
+		writeJSON(w, http.StatusOK, LinkListResponse{
+			Total:  len(matched),
+			Offset: offset,
+			Limit:  limit,
+			Items:  page,
+		})
+	}
+}
+
+func aggregatesHandler(s *StatsStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var resp AggregatesResponse
+		for _, item := range s.ListItems() {
+			resp.Links++
+			if resp.FirstCreated == nil || item.CreateTime.Before(*resp.FirstCreated) {
+				resp.FirstCreated = &item.CreateTime
+			}
+			if resp.LastCreated == nil || item.CreateTime.After(*resp.LastCreated) {
+				resp.LastCreated = &item.CreateTime
+			}
+			if len(item.VisitTime) == 0 {
+				resp.UnvisitedLinks++
+				continue
+			}
+			resp.VisitedLinks++
+			resp.Visits += len(item.VisitTime)
+			last := item.VisitTime[len(item.VisitTime)-1]
+			if resp.LastVisit == nil || last.After(*resp.LastVisit) {
+				resp.LastVisit = &last
+			}
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+func linkLifecycleHandler(s *StatsStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		item, exists := findItem(s, r.PathValue("id"))
+		if !exists {
+			writeError(w, "Link not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, NewLifecycle(item, time.Now()))
+	}
+}
+
+func lifecycleReportHandler(s *StatsStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		createdAfter, err := timeParam(query.Get("created_after"))
+		if err != nil {
+			writeError(w, "Expected RFC 3339 time 'created_after'", http.StatusBadRequest)
+			return
+		}
+		createdBefore, err := timeParam(query.Get("created_before"))
+		if err != nil {
+			writeError(w, "Expected RFC 3339 time 'created_before'", http.StatusBadRequest)
+			return
+		}
+
+		var items []StatsItem
+		for _, item := range s.ListItems() {
+			if !createdAfter.IsZero() && item.CreateTime.Before(createdAfter) {
+				continue
+			}
+			if !createdBefore.IsZero() && !item.CreateTime.Before(createdBefore) {
+				continue
+			}
+			items = append(items, redacted(item))
+		}
+		writeJSON(w, http.StatusOK, BuildLifecycleReport(items, time.Now()))
+	}
+}
+
+type RollupsResponse struct {
+	Resolution Resolution `json:"resolution"`
+	Buckets    []Bucket   `json:"buckets"`
+}
+
+func rollupsHandler(s *StatsStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		res := Hour
+		if v := query.Get("resolution"); v != "" {
+			var err error
+			res, err = ParseResolution(v)
+			if err != nil {
+				writeError(w, "Expected 'resolution' minute, hour or day", http.StatusBadRequest)
+				return
+			}
+		}
+		from, err := timeParam(query.Get("from"))
+		if err != nil {
+			writeError(w, "Expected RFC 3339 time 'from'", http.StatusBadRequest)
+			return
+		}
+		to, err := timeParam(query.Get("to"))
+		if err != nil {
+			writeError(w, "Expected RFC 3339 time 'to'", http.StatusBadRequest)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, RollupsResponse{
+			Resolution: res,
+			Buckets:    s.QueryRollups(res, from, to),
+		})
+	}
+}
+
+func intParam(value string, fallback int) (int, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(value)
+}
+
+func timeParam(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, message string, status int) {
+	writeJSON(w, status, errorResponse{Error: message})
+}
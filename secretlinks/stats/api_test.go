@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"secretlinks/middleware"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestAPIStorage() *StatsStorage {
+	base := time.Date(2025, 7, 15, 12, 0, 0, 0, time.UTC)
+	statsStorage := NewStatsStorage()
+	statsStorage.AddNewItem("first", base)
+	statsStorage.AddNewItem("second", base.Add(time.Hour))
+	statsStorage.AddNewItem("third", base.Add(2*time.Hour))
+	statsStorage.AppendVisitTime("second", base.Add(90*time.Minute))
+	statsStorage.AppendVisitTime("second", base.Add(100*time.Minute))
+	return statsStorage
+}
+
+func TestAPILink(t *testing.T) {
+	handler := NewAPIHandler(newTestAPIStorage())
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/links/"+middleware.RedactKey("second"), nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	var item StatsItem
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &item))
+	assert.Equal(t, middleware.RedactKey("second"), item.LinkKey)
+	assert.Equal(t, 2, len(item.VisitTime))
+}
+
+func TestAPINeverShowsKeys(t *testing.T) {
+	handler := NewAPIHandler(newTestAPIStorage())
+
+	for _, path := range []string{"/links", "/lifecycle", "/links/" + middleware.RedactKey("second") + "/lifecycle"} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		assert.Equal(t, http.StatusOK, w.Code, path)
+		assert.NotContains(t, w.Body.String(), `"second"`, path)
+	}
+
+	// A key is not an id: whoever knows it must not learn more.
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/links/second", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestAPILinkNotFound(t *testing.T) {
+	handler := NewAPIHandler(newTestAPIStorage())
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/links/missing", nil))
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), "Link not found")
+}
+
+func TestAPIListPagination(t *testing.T) {
+	handler := NewAPIHandler(newTestAPIStorage())
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/links?offset=1&limit=1", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp LinkListResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 3, resp.Total)
+	assert.Equal(t, 1, len(resp.Items))
+	assert.Equal(t, middleware.RedactKey("second"), resp.Items[0].LinkKey)
+}
+
+func TestAPIListFilters(t *testing.T) {
+	handler := NewAPIHandler(newTestAPIStorage())
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/links?visited=false&created_after=2025-07-15T12:30:00Z", nil))
+
+	var resp LinkListResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 1, resp.Total)
+	assert.Equal(t, middleware.RedactKey("third"), resp.Items[0].LinkKey)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/links?created_before=2025-07-15T13:00:00Z", nil))
+
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 1, resp.Total)
+	assert.Equal(t, middleware.RedactKey("first"), resp.Items[0].LinkKey)
+}
+
+func TestAPIListInvalidParams(t *testing.T) {
+	handler := NewAPIHandler(newTestAPIStorage())
+
+	for _, query := range []string{"limit=0", "offset=-1", "visited=maybe", "created_after=yesterday"} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/links?"+query, nil))
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+}
+
+func TestAPIAggregates(t *testing.T) {
+	handler := NewAPIHandler(newTestAPIStorage())
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/aggregates", nil))
+
+	var resp AggregatesResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 3, resp.Links)
+	assert.Equal(t, 1, resp.VisitedLinks)
+	assert.Equal(t, 2, resp.UnvisitedLinks)
+	assert.Equal(t, 2, resp.Visits)
+	assert.Equal(t, time.Date(2025, 7, 15, 13, 40, 0, 0, time.UTC), resp.LastVisit.UTC())
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// DeadLetter is a message the consumer gave up on, kept with the reason
+// so it can be inspected and replayed to its original topic later.
+type DeadLetter struct {
+	Topic     string            `json:"topic"`
+	Partition int               `json:"partition"`
+	Offset    int64             `json:"offset"`
+	Key       []byte            `json:"key"`
+	Value     []byte            `json:"value"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Reason    string            `json:"reason"`
+	Attempts  int               `json:"attempts"`
+	FailedAt  time.Time         `json:"failedat"`
+}
+
+func NewDeadLetter(msg kafka.Message, reason error, attempts int) DeadLetter {
+	dl := DeadLetter{
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Key:       msg.Key,
+		Value:     msg.Value,
+		Reason:    reason.Error(),
+		Attempts:  attempts,
+		FailedAt:  time.Now(),
+	}
+	if len(msg.Headers) > 0 {
+		dl.Headers = make(map[string]string, len(msg.Headers))
+		for _, h := range msg.Headers {
+			dl.Headers[h.Key] = string(h.Value)
+		}
+	}
+	return dl
+}
+
+// Message rebuilds the original kafka message for replay.
+func (dl DeadLetter) Message() kafka.Message {
+	msg := kafka.Message{
+		Topic: dl.Topic,
+		Key:   dl.Key,
+		Value: dl.Value,
+	}
+	for k, v := range dl.Headers {
+		msg.Headers = append(msg.Headers, kafka.Header{Key: k, Value: []byte(v)})
+	}
+	return msg
+}
+
+type MessageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+// DeadLetterFile stores dead letters as JSON lines in a local file.
+type DeadLetterFile struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewDeadLetterFile(path string) *DeadLetterFile {
+	return &DeadLetterFile{path: path}
+}
+
+func (f *DeadLetterFile) Append(dl DeadLetter) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(dl)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (f *DeadLetterFile) List() ([]DeadLetter, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.read()
+}
+
+// Replay writes the selected dead letters back to their topics and removes
+// them from the file. A nil selection replays everything. Letters that fail
+// to be written stay in the file.
+func (f *DeadLetterFile) Replay(ctx context.Context, writer MessageWriter, selected func(i int, dl DeadLetter) bool) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	letters, err := f.read()
+	if err != nil {
+		return 0, err
+	}
+
+	var remaining []DeadLetter
+	replayed := 0
+	var replayErr error
+	for i, dl := range letters {
+		if replayErr != nil || (selected != nil && !selected(i, dl)) {
+			remaining = append(remaining, dl)
+			continue
+		}
+		if err := writer.WriteMessages(ctx, dl.Message()); err != nil {
+			replayErr = err
+			remaining = append(remaining, dl)
+			continue
+		}
+		replayed++
+	}
+
+	if err := f.write(remaining); err != nil {
+		return replayed, err
+	}
+	return replayed, replayErr
+}
+
+func (f *DeadLetterFile) read() ([]DeadLetter, error) {
+	file, err := os.Open(f.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var letters []DeadLetter
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var dl DeadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &dl); err != nil {
+			return nil, err
+		}
+		letters = append(letters, dl)
+	}
+	return letters, scanner.Err()
+}
+
+func (f *DeadLetterFile) write(letters []DeadLetter) error {
+	tmp := f.path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(file)
+	for _, dl := range letters {
+		if err := encoder.Encode(dl); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path)
+}
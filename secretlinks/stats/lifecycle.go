@@ -0,0 +1,166 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// How a link's lifecycle ended.
+const (
+	EndExpiredByTime  = "expired_time"
+	EndExpiredByViews = "expired_views"
+	EndRevoked        = "revoked"
+)
+
+// Lifecycle joins the creation, visits and end of one link.
+type Lifecycle struct {
+	LinkKey         string     `json:"linkkey"`
+	Created         time.Time  `json:"created"`
+	FirstView       *time.Time `json:"firstview,omitempty"`
+	TimeToFirstView *float64   `json:"timetofirstview,omitempty"`
+	Views           int        `json:"views"`
+	Ended           *time.Time `json:"ended,omitempty"`
+	EndReason       string     `json:"endreason,omitempty"`
+}
+
+// NewLifecycle builds the lifecycle of an item. The link server reports
+// the end of a link when it is opened again or swept after its expiration
+// time, so until that event arrives a link whose views are used up or
+// whose expiration time has passed is treated as ended anyway.
+func NewLifecycle(item StatsItem, now time.Time) Lifecycle {
+	lc := Lifecycle{
+		LinkKey: item.LinkKey,
+		Created: item.CreateTime,
+		Views:   len(item.VisitTime),
+	}
+	if len(item.VisitTime) > 0 {
+		first := item.VisitTime[0]
+		ttfv := first.Sub(item.CreateTime).Seconds()
+		lc.FirstView = &first
+		lc.TimeToFirstView = &ttfv
+	}
+
+	switch {
+	case item.EndTime != nil:
+		lc.Ended = item.EndTime
+		lc.EndReason = item.EndReason
+	case item.MaxViews > 0 && len(item.VisitTime) >= item.MaxViews:
+		last := item.VisitTime[len(item.VisitTime)-1]
+		lc.Ended = &last
+		lc.EndReason = EndExpiredByViews
+	case !item.ExpiresAt.IsZero() && now.After(item.ExpiresAt):
+		expiresAt := item.ExpiresAt
+		lc.Ended = &expiresAt
+		lc.EndReason = EndExpiredByTime
+	}
+	return lc
+}
+
+// DurationSummary describes a distribution of durations in seconds.
+type DurationSummary struct {
+	Count int     `json:"count"`
+	Min   float64 `json:"min"`
+	Mean  float64 `json:"mean"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P95   float64 `json:"p95"`
+	P99   float64 `json:"p99"`
+	Max   float64 `json:"max"`
+}
+
+func summarize(values []float64) DurationSummary {
+	if len(values) == 0 {
+		return DurationSummary{}
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	sum := 0.0
+	for _, v := range sorted {
+		sum += v
+	}
+	return DurationSummary{
+		Count: len(sorted),
+		Min:   sorted[0],
+		Mean:  sum / float64(len(sorted)),
+		P50:   percentile(sorted, 50),
+		P90:   percentile(sorted, 90),
+		P95:   percentile(sorted, 95),
+		P99:   percentile(sorted, 99),
+		Max:   sorted[len(sorted)-1],
+	}
+}
+
+// percentile uses the nearest-rank method on sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// LifecycleReport summarises the lifecycles of a set of links. Fractions of
+// never opened and revoked links are taken over ended links, since an
+// active link may still be read; the expiry fractions are taken over
+// expired links.
+type LifecycleReport struct {
+	Links                  int             `json:"links"`
+	Active                 int             `json:"active"`
+	Ended                  int             `json:"ended"`
+	Opened                 int             `json:"opened"`
+	NeverOpened            int             `json:"neveropened"`
+	NeverOpenedFraction    float64         `json:"neveropenedfraction"`
+	ExpiredByTime          int             `json:"expiredbytime"`
+	ExpiredByViews         int             `json:"expiredbyviews"`
+	ExpiredByTimeFraction  float64         `json:"expiredbytimefraction"`
+	ExpiredByViewsFraction float64         `json:"expiredbyviewsfraction"`
+	Revoked                int             `json:"revoked"`
+	RevokedFraction        float64         `json:"revokedfraction"`
+	TimeToFirstView        DurationSummary `json:"timetofirstview"`
+}
+
+func BuildLifecycleReport(items []StatsItem, now time.Time) LifecycleReport {
+	var report LifecycleReport
+	var firstViews []float64
+	for _, item := range items {
+		lc := NewLifecycle(item, now)
+		report.Links++
+		if lc.TimeToFirstView != nil {
+			report.Opened++
+			firstViews = append(firstViews, *lc.TimeToFirstView)
+		}
+		if lc.Ended == nil {
+			report.Active++
+			continue
+		}
+		report.Ended++
+		if lc.Views == 0 {
+			report.NeverOpened++
+		}
+		switch lc.EndReason {
+		case EndExpiredByTime:
+			report.ExpiredByTime++
+		case EndExpiredByViews:
+			report.ExpiredByViews++
+		case EndRevoked:
+			report.Revoked++
+		}
+	}
+
+	report.NeverOpenedFraction = fraction(report.NeverOpened, report.Ended)
+	report.RevokedFraction = fraction(report.Revoked, report.Ended)
+	expired := report.ExpiredByTime + report.ExpiredByViews
+	report.ExpiredByTimeFraction = fraction(report.ExpiredByTime, expired)
+	report.ExpiredByViewsFraction = fraction(report.ExpiredByViews, expired)
+	report.TimeToFirstView = summarize(firstViews)
+	return report
+}
+
+func fraction(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total)
+}
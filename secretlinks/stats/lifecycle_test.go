@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"secretlinks/middleware"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewLifecycleInfersEnd(t *testing.T) {
+	base := time.Date(2025, 7, 15, 12, 0, 0, 0, time.UTC)
+	now := base.Add(3 * time.Hour)
+
+	byViews := NewLifecycle(StatsItem{
+		LinkKey:    "views",
+		CreateTime: base,
+		VisitTime:  []time.Time{base.Add(time.Minute), base.Add(2 * time.Minute)},
+		ExpiresAt:  base.Add(24 * time.Hour),
+		MaxViews:   2,
+	}, now)
+	assert.Equal(t, EndExpiredByViews, byViews.EndReason)
+	assert.Equal(t, base.Add(2*time.Minute), *byViews.Ended)
+	assert.Equal(t, 60.0, *byViews.TimeToFirstView)
+
+	byTime := NewLifecycle(StatsItem{
+		LinkKey:    "time",
+		CreateTime: base,
+		ExpiresAt:  base.Add(time.Hour),
+		MaxViews:   1,
+	}, now)
+	assert.Equal(t, EndExpiredByTime, byTime.EndReason)
+	assert.Nil(t, byTime.FirstView)
+
+	active := NewLifecycle(StatsItem{
+		LinkKey:    "active",
+		CreateTime: base,
+		ExpiresAt:  base.Add(24 * time.Hour),
+		MaxViews:   1,
+	}, now)
+	assert.Nil(t, active.Ended)
+}
+
+func TestBuildLifecycleReport(t *testing.T) {
+	base := time.Date(2025, 7, 15, 12, 0, 0, 0, time.UTC)
+	now := base.Add(48 * time.Hour)
+	revokedAt := base.Add(time.Hour)
+	items := []StatsItem{
+		{LinkKey: "a", CreateTime: base, VisitTime: []time.Time{base.Add(10 * time.Second)}, MaxViews: 1, ExpiresAt: base.Add(time.Hour)},
+		{LinkKey: "b", CreateTime: base, VisitTime: []time.Time{base.Add(30 * time.Second)}, MaxViews: 5, ExpiresAt: base.Add(time.Hour)},
+		{LinkKey: "c", CreateTime: base, MaxViews: 1, ExpiresAt: base.Add(time.Hour)},
+		{LinkKey: "d", CreateTime: base, MaxViews: 1, ExpiresAt: base.Add(time.Hour), EndTime: &revokedAt, EndReason: EndRevoked},
+		{LinkKey: "e", CreateTime: base, MaxViews: 1, ExpiresAt: now.Add(time.Hour)},
+	}
+
+	report := BuildLifecycleReport(items, now)
+
+	assert.Equal(t, 5, report.Links)
+	assert.Equal(t, 1, report.Active)
+	assert.Equal(t, 4, report.Ended)
+	assert.Equal(t, 2, report.Opened)
+	assert.Equal(t, 2, report.NeverOpened)
+	assert.Equal(t, 0.5, report.NeverOpenedFraction)
+	assert.Equal(t, 2, report.ExpiredByTime)
+	assert.Equal(t, 1, report.ExpiredByViews)
+	assert.InDelta(t, 2.0/3.0, report.ExpiredByTimeFraction, 1e-9)
+	assert.Equal(t, 1, report.Revoked)
+	assert.Equal(t, 2, report.TimeToFirstView.Count)
+	assert.Equal(t, 10.0, report.TimeToFirstView.P50)
+	assert.Equal(t, 30.0, report.TimeToFirstView.P99)
+	assert.Equal(t, 20.0, report.TimeToFirstView.Mean)
+}
+
+func TestHandleMessageJoinsLifecycle(t *testing.T) {
+	statsStorage := NewStatsStorage()
+	at := time.Date(2025, 7, 15, 12, 0, 0, 0, time.UTC)
+
+	// The expiry is consumed before the creation it belongs to.
+	expired := kafka.Message{Value: []byte(`{"linkkey":"k","nowtime":"2025-07-15T13:00:00Z","reason":"views"}`)}
+	_, err := handleMessage(KafkaReaderConfig{Topic: "expiredlinks", Storage: statsStorage}, expired)
+	assert.NoError(t, err)
+	created := kafka.Message{Value: []byte(`{"linkkey":"k","nowtime":"2025-07-15T12:00:00Z","expiresat":"2025-07-15T14:00:00Z","maxviews":2}`)}
+	_, err = handleMessage(KafkaReaderConfig{Topic: "newlinks", Storage: statsStorage}, created)
+	assert.NoError(t, err)
+
+	item, _ := statsStorage.GetItem("k")
+	assert.Equal(t, at, item.CreateTime)
+	assert.Equal(t, 2, item.MaxViews)
+	assert.Equal(t, EndExpiredByViews, item.EndReason)
+
+	w := httptest.NewRecorder()
+	NewAPIHandler(statsStorage).ServeHTTP(w, httptest.NewRequest("GET", "/links/"+middleware.RedactKey("k")+"/lifecycle", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var lc Lifecycle
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &lc))
+	assert.Equal(t, EndExpiredByViews, lc.EndReason)
+}
+
+func TestHandleMessageCountsDeniedViews(t *testing.T) {
+	statsStorage := NewStatsStorage()
+	created := kafka.Message{Value: []byte(`{"linkkey":"k","nowtime":"2025-07-15T12:00:00Z","maxviews":1}`)}
+	_, err := handleMessage(KafkaReaderConfig{Topic: "newlinks", Storage: statsStorage}, created)
+	assert.NoError(t, err)
+	denied := kafka.Message{Value: []byte(`{"linkkey":"k","nowtime":"2025-07-15T12:05:00Z"}`)}
+	_, err = handleMessage(KafkaReaderConfig{Topic: "deniedlinks", Storage: statsStorage}, denied)
+	assert.NoError(t, err)
+
+	item, _ := statsStorage.GetItem("k")
+	assert.Empty(t, item.VisitTime, "a refused view is not a visit")
+	assert.Nil(t, item.EndTime)
+	buckets := statsStorage.rollup.Query(Hour, time.Time{}, time.Time{})
+	if assert.Len(t, buckets, 1) {
+		assert.Equal(t, int64(1), buckets[0].Denied)
+		assert.Equal(t, int64(0), buckets[0].Viewed)
+	}
+}
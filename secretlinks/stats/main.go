@@ -2,63 +2,193 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
+	appconfig "secretlinks/config"
+	"secretlinks/events"
+	"secretlinks/middleware"
+	"secretlinks/tracing"
+	"sort"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/segmentio/kafka-go"
+	bolt "go.etcd.io/bbolt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("secretlinks/stats")
+
 type StatsItem struct {
 	LinkKey    string      `json:"linkkey"`
 	CreateTime time.Time   `json:"createtime"`
 	VisitTime  []time.Time `json:"visittime"`
+	ExpiresAt  time.Time   `json:"expiresat"`
+	MaxViews   int         `json:"maxviews"`
+	EndTime    *time.Time  `json:"endtime,omitempty"`
+	EndReason  string      `json:"endreason,omitempty"`
 }
 
-type KafkaStatsItem struct {
-	LinkKey string    `json:"linkkey"`
-	NowTime time.Time `json:"nowtime"`
-}
+type KafkaStatsItem = events.LinkEvent
 
 type StatsStorage struct {
 	mu    sync.RWMutex
 	items map[string]StatsItem
+	// ids maps the middleware.RedactKey id of every item's key to the
+	// key. Ids are derived when an item is stored, so the key id secret
+	// must be set before.
+	ids       map[string]string
+	rollup    *Rollup
+	retention RetentionPolicy
+	db        *bolt.DB
+	offsets   map[string]int64
 }
 
 func NewStatsStorage() *StatsStorage {
 	return &StatsStorage{
-		items: make(map[string]StatsItem),
+		items:     make(map[string]StatsItem),
+		ids:       make(map[string]string),
+		rollup:    NewRollup(),
+		retention: DefaultRetentionPolicy,
 	}
 }
 
-func (s *StatsStorage) AddNewItem(linkKey string, createTime time.Time) {
+func (s *StatsStorage) AddNewItem(linkKey string, createTime time.Time) error {
+	return s.addNewItem(KafkaStatsItem{LinkKey: linkKey, NowTime: createTime}, nil)
+}
+
+func (s *StatsStorage) AppendVisitTime(linkKey string, visitTime time.Time) error {
+	return s.appendVisitTime(linkKey, visitTime, nil)
+}
+
+func (s *StatsStorage) addNewItem(stat KafkaStatsItem, cp *Checkpoint) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.items[linkKey] = StatsItem{
-		LinkKey:    linkKey,
-		CreateTime: createTime,
+	item := StatsItem{
+		LinkKey:    stat.LinkKey,
+		CreateTime: stat.NowTime,
 		VisitTime:  []time.Time{},
+		MaxViews:   stat.MaxViews,
+	}
+	if stat.ExpiresAt != nil {
+		item.ExpiresAt = *stat.ExpiresAt
+	}
+	// Topics are consumed independently, so visits or the end of the
+	// link may have been seen first.
+	if existing, exists := s.items[stat.LinkKey]; exists {
+		item.VisitTime = existing.VisitTime
+		item.EndTime = existing.EndTime
+		item.EndReason = existing.EndReason
 	}
+	return s.apply(cp, &item, MetricCreated, stat.NowTime)
 }
 
-func (s *StatsStorage) AppendVisitTime(linkKey string, visitTime time.Time) {
+func (s *StatsStorage) appendVisitTime(linkKey string, visitTime time.Time, cp *Checkpoint) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if item, exists := s.items[linkKey]; exists {
-		item.VisitTime = append(item.VisitTime, visitTime)
-		s.items[linkKey] = item
+	item, exists := s.items[linkKey]
+	if exists {
+		visits := make([]time.Time, len(item.VisitTime), len(item.VisitTime)+1)
+		copy(visits, item.VisitTime)
+		item.VisitTime = append(visits, visitTime)
 	} else {
-		s.items[linkKey] = StatsItem{
+		item = StatsItem{
 			LinkKey:    linkKey,
 			CreateTime: visitTime,
 			VisitTime:  []time.Time{},
 		}
 	}
+	return s.apply(cp, &item, MetricViewed, visitTime)
+}
+
+// RecordEvent counts an event that does not change a link's item.
+func (s *StatsStorage) RecordEvent(metric string, at time.Time) error {
+	return s.recordEvent(metric, at, nil)
+}
+
+func (s *StatsStorage) recordEvent(metric string, at time.Time, cp *Checkpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.apply(cp, nil, metric, at)
+}
+
+// EndItem marks the end of a link's lifecycle and counts it under metric.
+func (s *StatsStorage) EndItem(linkKey, reason, metric string, at time.Time) error {
+	return s.endItem(linkKey, reason, metric, at, nil)
+}
+
+func (s *StatsStorage) endItem(linkKey, reason, metric string, at time.Time, cp *Checkpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, exists := s.items[linkKey]
+	if !exists {
+		item = StatsItem{
+			LinkKey:    linkKey,
+			CreateTime: at,
+			VisitTime:  []time.Time{},
+		}
+	}
+	if item.EndTime == nil {
+		item.EndTime = &at
+		item.EndReason = reason
+	}
+	return s.apply(cp, &item, metric, at)
+}
+
+// apply counts the event, stores the item and persists both. The caller
+// holds s.mu.
+func (s *StatsStorage) apply(cp *Checkpoint, item *StatsItem, metric string, at time.Time) error {
+	previous, existed := s.rollup.buckets[minuteBucket(at)]
+	key := s.rollup.Record(metric, at)
+	if err := s.persist(cp, item, []bucketKey{key}, nil); err != nil {
+		if existed {
+			s.rollup.buckets[key] = previous
+		} else {
+			delete(s.rollup.buckets, key)
+		}
+		return err
+	}
+	if item != nil {
+		s.store(*item)
+	}
+	return nil
+}
+
+// store keeps the item and indexes it by id. The caller holds s.mu.
+func (s *StatsStorage) store(item StatsItem) {
+	if _, exists := s.items[item.LinkKey]; !exists {
+		s.ids[middleware.RedactKey(item.LinkKey)] = item.LinkKey
+	}
+	s.items[item.LinkKey] = item
+}
+
+// CompactRollups downsamples buckets past their retention.
+func (s *StatsStorage) CompactRollups(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed, removed := s.rollup.Compact(now, s.retention)
+	if len(changed) == 0 && len(removed) == 0 {
+		return nil
+	}
+	return s.persist(nil, nil, changed, removed)
+}
+
+func (s *StatsStorage) QueryRollups(res Resolution, from, to time.Time) []Bucket {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.rollup.Query(res, from, to)
 }
 
 func (s *StatsStorage) ShowStorage() {
@@ -69,12 +199,12 @@ func (s *StatsStorage) ShowStorage() {
 	for _, v := range s.items {
 		if len(v.VisitTime) == 0 {
 			fmt.Printf("ID: %s | Created: %s | Visits: %v\n",
-				v.LinkKey,
+				middleware.RedactKey(v.LinkKey),
 				v.CreateTime.Format("2006-01-02 15:04:05"),
 				len(v.VisitTime))
 		} else {
 			fmt.Printf("ID: %s | Created: %s | Visits: %v, last visit: %v\n",
-				v.LinkKey,
+				middleware.RedactKey(v.LinkKey),
 				v.CreateTime.Format("2006-01-02 15:04:05"),
 				len(v.VisitTime),
 				v.VisitTime[len(v.VisitTime)-1].Format("2006-01-02 15:04:05"))
@@ -88,101 +218,344 @@ func (s *StatsStorage) ShowItem(linkKey string) {
 
 	if item, exists := s.items[linkKey]; exists {
 		fmt.Printf("ID: %s | Created: %s | Visits: %d\n",
-			item.LinkKey,
+			middleware.RedactKey(item.LinkKey),
 			item.CreateTime.Format("2006-01-02 15:04:05"),
 			len(item.VisitTime))
 	}
 }
 
+// GetItem returns a link's statistics. Visit slices are never modified in
+// place, so the result may be used without holding the lock.
+func (s *StatsStorage) GetItem(linkKey string) (StatsItem, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	item, exists := s.items[linkKey]
+	return item, exists
+}
+
+// GetItemByID returns the statistics of the link whose key has the given
+// middleware.RedactKey id.
+func (s *StatsStorage) GetItemByID(id string) (StatsItem, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, exists := s.ids[id]
+	if !exists {
+		return StatsItem{}, false
+	}
+	item, exists := s.items[key]
+	return item, exists
+}
+
+func (s *StatsStorage) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.items)
+}
+
+func (s *StatsStorage) RollupBuckets() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.rollup.buckets)
+}
+
+// ListItems returns all items ordered by creation time.
+func (s *StatsStorage) ListItems() []StatsItem {
+	s.mu.RLock()
+	items := make([]StatsItem, 0, len(s.items))
+	for _, item := range s.items {
+		items = append(items, item)
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].CreateTime.Equal(items[j].CreateTime) {
+			return items[i].LinkKey < items[j].LinkKey
+		}
+		return items[i].CreateTime.Before(items[j].CreateTime)
+	})
+	return items
+}
+
 type KafkaReaderConfig struct {
-	Brokers []string
-	GroupID string
-	Topic   string
-	Storage *StatsStorage
+	Brokers    []string
+	GroupID    string
+	Topic      string
+	Topics     appconfig.TopicsConfig // zero value means the default topic names
+	Storage    *StatsStorage
+	Retry      RetryPolicy
+	DeadLetter *DeadLetterFile
+}
+
+type MessageReader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
 }
 
 func RunKafkaReader(ctx context.Context, wg *sync.WaitGroup, config KafkaReaderConfig) {
 	defer wg.Done()
 
+	// The group offset wins when kafka still has it. Without one, a storage
+	// that has seen the topic before starts from the beginning and skips
+	// what it already applied instead of jumping to the end.
+	startOffset := kafka.LastOffset
+	if config.Storage.HasCheckpoints(config.Topic) {
+		startOffset = kafka.FirstOffset
+	}
+
 	reader := kafka.NewReader(kafka.ReaderConfig{
 		Brokers:     config.Brokers,
 		Topic:       config.Topic,
 		GroupID:     config.GroupID,
-		StartOffset: kafka.LastOffset,
+		StartOffset: startOffset,
 	})
 	defer reader.Close()
 
+	lagCtx, stopLag := context.WithCancel(ctx)
+	defer stopLag()
+	go watchLag(lagCtx, config.Topic, reader, lagInterval)
+
+	consume(ctx, reader, config)
+}
+
+func consume(ctx context.Context, reader MessageReader, config KafkaReaderConfig) {
+	fetchFailures := 0
 	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-			msg, err := reader.FetchMessage(ctx)
+		msg, err := reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			fetchFailures++
+			consumerErrors.WithLabelValues(config.Topic, "fetch").Inc()
+			delay := config.Retry.Backoff(fetchFailures)
+			fmt.Printf("Consumer error (topic %s): %v, retrying in %v\n", config.Topic, err, delay)
+			if !sleepContext(ctx, delay) {
+				return
+			}
+			continue
+		}
+		fetchFailures = 0
+
+		msgCtx, span := tracer.Start(tracing.ExtractKafka(ctx, &msg), "consume "+config.Topic,
+			trace.WithSpanKind(trace.SpanKindConsumer),
+			trace.WithAttributes(
+				attribute.Int("messaging.kafka.partition", msg.Partition),
+				attribute.Int64("messaging.kafka.offset", msg.Offset),
+			))
+
+		var stat KafkaStatsItem
+		attempts, err := config.Retry.Do(msgCtx, func() (err error) {
+			_, applySpan := tracer.Start(msgCtx, "stats.apply")
+			defer applySpan.End()
+			stat, err = handleMessage(config, msg)
 			if err != nil {
-				if ctx.Err() != nil {
+				applySpan.RecordError(err)
+			}
+			return err
+		})
+		span.SetAttributes(attribute.Int("stats.attempts", attempts))
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			fmt.Printf("Giving up on message (topic %s, offset %d) after %d attempt(s): %v\n", config.Topic, msg.Offset, attempts, err)
+			messagesFailed.WithLabelValues(config.Topic).Inc()
+			// The message is only committed once it is kept somewhere,
+			// and giving up here would stop the topic for good.
+			for failures := 1; !deadLetter(ctx, config, msg, err, attempts); failures++ {
+				consumerErrors.WithLabelValues(config.Topic, "deadletter").Inc()
+				if !sleepContext(ctx, config.Retry.Backoff(failures)) {
 					return
 				}
-				fmt.Printf("Consumer error (topic %s): %v\n", config.Topic, err)
-				continue
 			}
+		} else {
+			messagesProcessed.WithLabelValues(config.Topic).Inc()
+		}
 
-			var stat KafkaStatsItem
-			if err := json.Unmarshal(msg.Value, &stat); err != nil {
-				fmt.Printf("JSON decode error (topic %s): %v\n", config.Topic, err)
-				reader.CommitMessages(ctx, msg)
-				continue
-			}
+		_, err = config.Retry.Do(ctx, func() error {
+			return reader.CommitMessages(ctx, msg)
+		})
+		if err != nil {
+			consumerErrors.WithLabelValues(config.Topic, "commit").Inc()
+			fmt.Printf("Commit error (topic %s): %v\n", config.Topic, err)
+		} else if stat.LinkKey != "" {
+			config.Storage.ShowItem(stat.LinkKey)
+		}
+	}
+}
 
-			switch config.Topic {
-			case "newlinks":
-				config.Storage.AddNewItem(stat.LinkKey, stat.NowTime)
-			case "updatelinks":
-				config.Storage.AppendVisitTime(stat.LinkKey, stat.NowTime)
-			}
+func handleMessage(config KafkaReaderConfig, msg kafka.Message) (KafkaStatsItem, error) {
+	stat, err := events.Decode(msg)
+	if err != nil {
+		decodeErrors.WithLabelValues(config.Topic, events.ContentType(msg)).Inc()
+		return KafkaStatsItem{}, &PermanentError{Err: fmt.Errorf("decode %s: %w", events.ContentType(msg), err)}
+	}
 
-			if err := reader.CommitMessages(ctx, msg); err != nil {
-				fmt.Printf("Commit error (topic %s): %v\n", config.Topic, err)
-			} else {
-				config.Storage.ShowItem(stat.LinkKey)
-			}
+	cp := Checkpoint{Topic: config.Topic, Partition: msg.Partition, Offset: msg.Offset}
+	if config.Storage.Processed(cp) {
+		// Applied before a restart, only the kafka commit was lost.
+		return stat, nil
+	}
+
+	topics := config.Topics
+	if topics == (appconfig.TopicsConfig{}) {
+		topics = appconfig.Default().Kafka.Topics
+	}
+
+	switch config.Topic {
+	case topics.NewLinks:
+		err = config.Storage.addNewItem(stat, &cp)
+	case topics.UpdateLinks:
+		err = config.Storage.appendVisitTime(stat.LinkKey, stat.NowTime, &cp)
+	case topics.ExpiredLinks:
+		reason := EndExpiredByTime
+		if stat.Reason == events.ReasonViews {
+			reason = EndExpiredByViews
 		}
+		err = config.Storage.endItem(stat.LinkKey, reason, MetricExpired, stat.NowTime, &cp)
+	case topics.RevokedLinks:
+		err = config.Storage.endItem(stat.LinkKey, EndRevoked, MetricRevoked, stat.NowTime, &cp)
+	case topics.DeniedLinks:
+		// A refused view does not change the link, it is only counted.
+		err = config.Storage.recordEvent(MetricDenied, stat.NowTime, &cp)
+	default:
+		return KafkaStatsItem{}, &PermanentError{Err: fmt.Errorf("unexpected topic %q", config.Topic)}
+	}
+	if err != nil {
+		return KafkaStatsItem{}, fmt.Errorf("store: %w", err)
+	}
+	return stat, nil
+}
+
+// deadLetter stores a message that could not be processed. It reports
+// whether the message may be committed.
+func deadLetter(ctx context.Context, config KafkaReaderConfig, msg kafka.Message, reason error, attempts int) bool {
+	if config.DeadLetter == nil {
+		return true
+	}
+	if msg.Topic == "" {
+		msg.Topic = config.Topic
+	}
+	_, err := config.Retry.Do(ctx, func() error {
+		return config.DeadLetter.Append(NewDeadLetter(msg, reason, attempts))
+	})
+	if err != nil {
+		fmt.Printf("Dead-letter error (topic %s): %v\n", config.Topic, err)
+		return false
 	}
+	messagesDeadLettered.WithLabelValues(config.Topic).Inc()
+	return true
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "deadletter" {
+		os.Exit(runDeadLetterCommand(os.Args[2:]))
+	}
+
+	cfg, err := appconfig.Load("stats", os.Args[1:], os.Stdout)
+	if errors.Is(err, appconfig.ErrPrintConfig) {
+		return
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Printf("Configuration error: %v\n", err)
+		os.Exit(2)
+	}
+
 	fmt.Println("Stats module activated")
+	if cfg.KeyIDs.Secret != "" {
+		middleware.SetKeyIDSecret(cfg.KeyIDs.Secret)
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	kafkaConfig := struct {
-		Brokers []string
-		GroupID string
-	}{
-		Brokers: []string{"localhost:9092"},
-		GroupID: "links-group",
+	shutdownTracing, err := tracing.Setup(ctx, "secretlinks-stats", cfg.Tracing.Exporter)
+	if err != nil {
+		fmt.Printf("Tracing error: %v\n", err)
+		os.Exit(1)
 	}
+	defer shutdownTracing(context.Background())
 
 	storage := NewStatsStorage()
+	if cfg.Stats.DB != "" {
+		storage, err = OpenStatsStorage(cfg.Stats.DB)
+		if err != nil {
+			fmt.Printf("Stats storage error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	defer storage.Close()
+	storage.retention = RetentionPolicy(cfg.Stats.Retention)
+	deadLetters := NewDeadLetterFile(cfg.Stats.DeadLetter)
 	var wg sync.WaitGroup
 
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
 
-	topics := []string{"newlinks", "updatelinks"}
+	topics := cfg.Kafka.Topics.All()
 	wg.Add(len(topics))
 
 	for _, topic := range topics {
 		go RunKafkaReader(ctx, &wg, KafkaReaderConfig{
-			Brokers: kafkaConfig.Brokers,
-			GroupID: kafkaConfig.GroupID,
-			Topic:   topic,
-			Storage: storage,
+			Brokers:    cfg.Kafka.Brokers,
+			GroupID:    cfg.Kafka.GroupID,
+			Topic:      topic,
+			Topics:     cfg.Kafka.Topics,
+			Storage:    storage,
+			Retry:      DefaultRetryPolicy,
+			DeadLetter: deadLetters,
 		})
 	}
 
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				if err := storage.CompactRollups(now); err != nil {
+					fmt.Printf("Rollup compaction error: %v\n", err)
+				}
+			}
+		}
+	}()
+
+	var server *http.Server
+	if cfg.Stats.Addr != "" {
+		if cfg.KeyIDs.Secret == "" {
+			fmt.Println("WARNING: key_ids.secret is not set, so link ids in the stats API change on every restart " +
+				"and do not match the link server's logs; give both services the same SECRETLINKS_KEY_ID_SECRET")
+		}
+		RegisterStorageMetrics(prometheus.DefaultRegisterer, storage)
+		mux := http.NewServeMux()
+		mux.Handle("GET /metrics", promhttp.Handler())
+		mux.Handle("/", NewAPIHandler(storage))
+		server = &http.Server{Addr: cfg.Stats.Addr, Handler: mux}
+		go func() {
+			fmt.Printf("Stats API listening on %s\n", cfg.Stats.Addr)
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				fmt.Printf("Stats API error: %v\n", err)
+			}
+		}()
+	}
+
 	<-sigCh
 	cancel()
+	if server != nil {
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		server.Shutdown(shutdownCtx)
+		shutdownCancel()
+	}
 	wg.Wait()
 
 	fmt.Println("Final statistics:")
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/segmentio/kafka-go"
+)
+
+const metricsNamespace = "secretlinks_stats"
+
+var (
+	consumerLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "consumer_lag",
+		Help:      "Messages behind the high water mark, as reported by the reader.",
+	}, []string{"topic"})
+
+	messagesProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "messages_processed_total",
+		Help:      "Messages applied to the stats storage.",
+	}, []string{"topic"})
+
+	messagesFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "messages_failed_total",
+		Help:      "Messages given up on after all retries.",
+	}, []string{"topic"})
+
+	messagesDeadLettered = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "messages_dead_lettered_total",
+		Help:      "Messages written to the dead-letter file.",
+	}, []string{"topic"})
+
+	decodeErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "decode_errors_total",
+		Help:      "Messages that could not be decoded, by content type.",
+	}, []string{"topic", "content_type"})
+
+	consumerErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "consumer_errors_total",
+		Help:      "Failed fetch and commit calls.",
+	}, []string{"topic", "operation"})
+)
+
+// lagInterval is how often the consumer lag is read from the reader.
+const lagInterval = 15 * time.Second
+
+// ReaderStats is the part of *kafka.Reader the lag is read from.
+type ReaderStats interface {
+	Stats() kafka.ReaderStats
+}
+
+// watchLag records how far the consumer is behind every interval until ctx
+// is done, so the gauge keeps moving while no messages arrive.
+func watchLag(ctx context.Context, topic string, reader ReaderStats, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		consumerLag.WithLabelValues(topic).Set(float64(max(reader.Stats().Lag, 0)))
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RegisterStorageMetrics exports the size of the storage, read on every scrape.
+func RegisterStorageMetrics(registerer prometheus.Registerer, s *StatsStorage) {
+	factory := promauto.With(registerer)
+	factory.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "storage_links",
+		Help:      "Links held in the stats storage.",
+	}, func() float64 { return float64(s.Len()) })
+	factory.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "storage_rollup_buckets",
+		Help:      "Time buckets held in the stats storage.",
+	}, func() float64 { return float64(s.RollupBuckets()) })
+}
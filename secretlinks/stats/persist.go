@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	itemsBucket   = []byte("items")
+	offsetsBucket = []byte("offsets")
+	rollupsBucket = []byte("rollups")
+)
+
+// Checkpoint identifies the last message of a partition applied to the storage.
+type Checkpoint struct {
+	Topic     string
+	Partition int
+	Offset    int64
+}
+
+func (c Checkpoint) key() []byte {
+	return []byte(fmt.Sprintf("%s/%d", c.Topic, c.Partition))
+}
+
+// OpenStatsStorage loads the storage from a bolt database, creating it if
+// needed. Every change is written through together with the offset of the
+// message that caused it, so after a restart already applied messages are
+// recognised and skipped.
+func OpenStatsStorage(path string) (*StatsStorage, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	s := NewStatsStorage()
+	s.db = db
+	s.offsets = make(map[string]int64)
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		items, err := tx.CreateBucketIfNotExists(itemsBucket)
+		if err != nil {
+			return err
+		}
+		offsets, err := tx.CreateBucketIfNotExists(offsetsBucket)
+		if err != nil {
+			return err
+		}
+		rollups, err := tx.CreateBucketIfNotExists(rollupsBucket)
+		if err != nil {
+			return err
+		}
+		err = rollups.ForEach(func(k, v []byte) error {
+			key, err := parseBucketKey(string(k))
+			if err != nil {
+				return err
+			}
+			var counters Counters
+			if err := json.Unmarshal(v, &counters); err != nil {
+				return fmt.Errorf("rollup %s: %w", k, err)
+			}
+			s.rollup.buckets[key] = counters
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = items.ForEach(func(k, v []byte) error {
+			var item StatsItem
+			if err := json.Unmarshal(v, &item); err != nil {
+				return fmt.Errorf("item %s: %w", k, err)
+			}
+			s.store(item)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return offsets.ForEach(func(k, v []byte) error {
+			s.offsets[string(k)] = int64(binary.BigEndian.Uint64(v))
+			return nil
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *StatsStorage) Close() error {
+	if s.db == nil {
+		return nil
+	}
+	return s.db.Close()
+}
+
+// Processed reports whether the message at the checkpoint was already applied.
+func (s *StatsStorage) Processed(cp Checkpoint) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	offset, exists := s.offsets[string(cp.key())]
+	return exists && cp.Offset <= offset
+}
+
+// HasCheckpoints reports whether any message of the topic was applied.
+func (s *StatsStorage) HasCheckpoints(topic string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	prefix := topic + "/"
+	for k := range s.offsets {
+		if len(k) > len(prefix) && k[:len(prefix)] == prefix {
+			return true
+		}
+	}
+	return false
+}
+
+// persist writes the item, the rollup buckets and the checkpoint in one
+// transaction. The caller holds s.mu and has already applied the changes
+// to the rollup.
+func (s *StatsStorage) persist(cp *Checkpoint, item *StatsItem, changed, removed []bucketKey) error {
+	if s.db == nil {
+		return nil
+	}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if item != nil {
+			value, err := json.Marshal(item)
+			if err != nil {
+				return err
+			}
+			if err := tx.Bucket(itemsBucket).Put([]byte(item.LinkKey), value); err != nil {
+				return err
+			}
+		}
+		rollups := tx.Bucket(rollupsBucket)
+		for _, key := range removed {
+			if err := rollups.Delete([]byte(key.String())); err != nil {
+				return err
+			}
+		}
+		for _, key := range changed {
+			value, err := json.Marshal(s.rollup.buckets[key])
+			if err != nil {
+				return err
+			}
+			if err := rollups.Put([]byte(key.String()), value); err != nil {
+				return err
+			}
+		}
+		if cp == nil {
+			return nil
+		}
+		offset := make([]byte, 8)
+		binary.BigEndian.PutUint64(offset, uint64(cp.Offset))
+		return tx.Bucket(offsetsBucket).Put(cp.key(), offset)
+	})
+	if err != nil {
+		return err
+	}
+	if cp != nil {
+		s.offsets[string(cp.key())] = cp.Offset
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// RetryPolicy describes how many times an operation is attempted and how
+// long to wait between attempts. The delay doubles after every failure.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     30 * time.Second,
+}
+
+// Backoff returns the delay after the given failed attempt (starting at 1).
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	delay := p.InitialBackoff
+	for i := 1; i < attempt && delay < p.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	return delay
+}
+
+// Do runs fn until it succeeds, returns a permanent error, the attempts
+// are exhausted or ctx is cancelled. It returns the last error and the
+// number of attempts made.
+func (p RetryPolicy) Do(ctx context.Context, fn func() error) (int, error) {
+	attempt := 0
+	for {
+		attempt++
+		err := fn()
+		if err == nil {
+			return attempt, nil
+		}
+		var permanent *PermanentError
+		if errors.As(err, &permanent) || attempt >= p.MaxAttempts {
+			return attempt, err
+		}
+		if !sleepContext(ctx, p.Backoff(attempt)) {
+			return attempt, err
+		}
+	}
+}
+
+// PermanentError marks a failure that retrying cannot fix, such as a
+// message that does not decode.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string { return e.Err.Error() }
+
+func (e *PermanentError) Unwrap() error { return e.Err }
+
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
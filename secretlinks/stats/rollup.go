@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	MetricCreated = "created"
+	MetricViewed  = "viewed"
+	MetricExpired = "expired"
+	MetricRevoked = "revoked"
+	MetricDenied  = "denied"
+)
+
+type Counters struct {
+	Created int64 `json:"created"`
+	Viewed  int64 `json:"viewed"`
+	Expired int64 `json:"expired"`
+	Revoked int64 `json:"revoked"`
+	Denied  int64 `json:"denied"`
+}
+
+func (c *Counters) Add(metric string) {
+	switch metric {
+	case MetricCreated:
+		c.Created++
+	case MetricViewed:
+		c.Viewed++
+	case MetricExpired:
+		c.Expired++
+	case MetricRevoked:
+		c.Revoked++
+	case MetricDenied:
+		c.Denied++
+	}
+}
+
+func (c *Counters) Merge(o Counters) {
+	c.Created += o.Created
+	c.Viewed += o.Viewed
+	c.Expired += o.Expired
+	c.Revoked += o.Revoked
+	c.Denied += o.Denied
+}
+
+type Resolution string
+
+const (
+	Minute Resolution = "minute"
+	Hour   Resolution = "hour"
+	Day    Resolution = "day"
+)
+
+// resolutions is ordered from fine to coarse; data moves along it as it ages.
+var resolutions = []Resolution{Minute, Hour, Day}
+
+func ParseResolution(name string) (Resolution, error) {
+	for _, res := range resolutions {
+		if string(res) == name {
+			return res, nil
+		}
+	}
+	return "", fmt.Errorf("unknown resolution %q", name)
+}
+
+func (r Resolution) Duration() time.Duration {
+	switch r {
+	case Minute:
+		return time.Minute
+	case Hour:
+		return time.Hour
+	}
+	return 24 * time.Hour
+}
+
+func (r Resolution) level() int {
+	for i, res := range resolutions {
+		if res == r {
+			return i
+		}
+	}
+	return len(resolutions) - 1
+}
+
+// RetentionPolicy says how long buckets are kept at each resolution. Older
+// minute buckets are folded into hours, older hours into days, and days
+// past their retention are dropped.
+type RetentionPolicy struct {
+	Minute time.Duration
+	Hour   time.Duration
+	Day    time.Duration
+}
+
+var DefaultRetentionPolicy = RetentionPolicy{
+	Minute: 6 * time.Hour,
+	Hour:   30 * 24 * time.Hour,
+	Day:    2 * 365 * 24 * time.Hour,
+}
+
+func (p RetentionPolicy) For(r Resolution) time.Duration {
+	switch r {
+	case Minute:
+		return p.Minute
+	case Hour:
+		return p.Hour
+	}
+	return p.Day
+}
+
+type Bucket struct {
+	Resolution Resolution `json:"resolution"`
+	Start      time.Time  `json:"start"`
+	Counters
+}
+
+type bucketKey struct {
+	Resolution Resolution
+	Start      int64
+}
+
+func (k bucketKey) String() string {
+	return string(k.Resolution) + "/" + strconv.FormatInt(k.Start, 10)
+}
+
+func parseBucketKey(s string) (bucketKey, error) {
+	name, start, found := strings.Cut(s, "/")
+	if !found {
+		return bucketKey{}, fmt.Errorf("malformed bucket key %q", s)
+	}
+	res, err := ParseResolution(name)
+	if err != nil {
+		return bucketKey{}, err
+	}
+	unix, err := strconv.ParseInt(start, 10, 64)
+	if err != nil {
+		return bucketKey{}, err
+	}
+	return bucketKey{Resolution: res, Start: unix}, nil
+}
+
+// Rollup keeps event counters in time buckets. It is not safe for
+// concurrent use; StatsStorage guards it with its mutex.
+type Rollup struct {
+	buckets map[bucketKey]Counters
+}
+
+func NewRollup() *Rollup {
+	return &Rollup{buckets: make(map[bucketKey]Counters)}
+}
+
+func minuteBucket(at time.Time) bucketKey {
+	return bucketKey{Resolution: Minute, Start: at.Truncate(time.Minute).Unix()}
+}
+
+// Record counts the event in its minute bucket and returns the bucket key.
+func (r *Rollup) Record(metric string, at time.Time) bucketKey {
+	key := minuteBucket(at)
+	counters := r.buckets[key]
+	counters.Add(metric)
+	r.buckets[key] = counters
+	return key
+}
+
+// Compact downsamples buckets that are past their retention. It returns the
+// keys that were changed and the keys that were removed; a bucket that was
+// merged into and then removed itself is only reported as removed.
+func (r *Rollup) Compact(now time.Time, policy RetentionPolicy) (changed, removed []bucketKey) {
+	for i, res := range resolutions {
+		cutoff := now.Add(-policy.For(res)).Unix()
+		for key, counters := range r.buckets {
+			if key.Resolution != res || key.Start+int64(res.Duration().Seconds()) > cutoff {
+				continue
+			}
+			delete(r.buckets, key)
+			removed = append(removed, key)
+			if i+1 == len(resolutions) {
+				continue
+			}
+			coarser := resolutions[i+1]
+			target := bucketKey{
+				Resolution: coarser,
+				Start:      time.Unix(key.Start, 0).Truncate(coarser.Duration()).Unix(),
+			}
+			merged := r.buckets[target]
+			merged.Merge(counters)
+			r.buckets[target] = merged
+			changed = append(changed, target)
+		}
+	}
+	changed = slices.DeleteFunc(changed, func(key bucketKey) bool {
+		return slices.Contains(removed, key)
+	})
+	return changed, removed
+}
+
+// Query returns buckets at the requested resolution between from
+// (inclusive) and to (exclusive); zero times leave the range open. Finer
+// data is summed up; data that was already downsampled past the requested
+// resolution is returned at its own, coarser resolution.
+func (r *Rollup) Query(res Resolution, from, to time.Time) []Bucket {
+	merged := make(map[bucketKey]Counters)
+	for key, counters := range r.buckets {
+		start := time.Unix(key.Start, 0)
+		target := key
+		if key.Resolution.level() < res.level() {
+			target = bucketKey{Resolution: res, Start: start.Truncate(res.Duration()).Unix()}
+		}
+		targetStart := time.Unix(target.Start, 0)
+		if !from.IsZero() && !targetStart.Add(target.Resolution.Duration()).After(from) {
+			continue
+		}
+		if !to.IsZero() && !targetStart.Before(to) {
+			continue
+		}
+		sum := merged[target]
+		sum.Merge(counters)
+		merged[target] = sum
+	}
+
+	result := make([]Bucket, 0, len(merged))
+	for key, counters := range merged {
+		result = append(result, Bucket{
+			Resolution: key.Resolution,
+			Start:      time.Unix(key.Start, 0).UTC(),
+			Counters:   counters,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Start.Equal(result[j].Start) {
+			return result[i].Resolution.level() > result[j].Resolution.level()
+		}
+		return result[i].Start.Before(result[j].Start)
+	})
+	return result
+}
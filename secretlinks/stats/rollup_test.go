@@ -0,0 +1,90 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRollupQueryAggregatesFinerBuckets(t *testing.T) {
+	base := time.Date(2025, 7, 15, 12, 0, 0, 0, time.UTC)
+	rollup := NewRollup()
+	rollup.Record(MetricCreated, base.Add(time.Minute))
+	rollup.Record(MetricCreated, base.Add(30*time.Minute))
+	rollup.Record(MetricViewed, base.Add(31*time.Minute))
+	rollup.Record(MetricExpired, base.Add(90*time.Minute))
+
+	minutes := rollup.Query(Minute, time.Time{}, time.Time{})
+	assert.Equal(t, 4, len(minutes))
+
+	hours := rollup.Query(Hour, time.Time{}, time.Time{})
+	assert.Equal(t, 2, len(hours))
+	assert.Equal(t, base, hours[0].Start)
+	assert.Equal(t, Counters{Created: 2, Viewed: 1}, hours[0].Counters)
+	assert.Equal(t, Counters{Expired: 1}, hours[1].Counters)
+
+	ranged := rollup.Query(Hour, base.Add(time.Hour), time.Time{})
+	assert.Equal(t, 1, len(ranged))
+	assert.Equal(t, int64(1), ranged[0].Expired)
+}
+
+func TestRollupCompactDownsamples(t *testing.T) {
+	base := time.Date(2025, 7, 15, 12, 0, 0, 0, time.UTC)
+	policy := RetentionPolicy{Minute: time.Hour, Hour: 24 * time.Hour, Day: 7 * 24 * time.Hour}
+	rollup := NewRollup()
+	rollup.Record(MetricCreated, base.Add(5*time.Minute))
+	rollup.Record(MetricViewed, base.Add(10*time.Minute))
+	rollup.Record(MetricRevoked, base.Add(3*time.Hour))
+
+	changed, removed := rollup.Compact(base.Add(3*time.Hour+30*time.Minute), policy)
+	assert.Equal(t, 2, len(removed))
+	assert.Equal(t, 2, len(changed))
+
+	buckets := rollup.Query(Minute, time.Time{}, time.Time{})
+	assert.Equal(t, 2, len(buckets))
+	assert.Equal(t, Hour, buckets[0].Resolution)
+	assert.Equal(t, Counters{Created: 1, Viewed: 1}, buckets[0].Counters)
+	assert.Equal(t, Minute, buckets[1].Resolution)
+
+	rollup.Compact(base.Add(30*24*time.Hour), policy)
+	assert.Equal(t, 0, len(rollup.Query(Day, time.Time{}, time.Time{})))
+}
+
+func TestRollupsArePersisted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.db")
+	at := time.Date(2025, 7, 15, 12, 0, 0, 0, time.UTC)
+
+	statsStorage, err := OpenStatsStorage(path)
+	assert.NoError(t, err)
+	assert.NoError(t, statsStorage.AddNewItem("key", at))
+	assert.NoError(t, statsStorage.RecordEvent(MetricExpired, at))
+	assert.NoError(t, statsStorage.Close())
+
+	reopened, err := OpenStatsStorage(path)
+	assert.NoError(t, err)
+	defer reopened.Close()
+
+	buckets := reopened.QueryRollups(Day, time.Time{}, time.Time{})
+	assert.Equal(t, 1, len(buckets))
+	assert.Equal(t, Counters{Created: 1, Expired: 1}, buckets[0].Counters)
+}
+
+func TestRollupCompactDoesNotPersistRemovedBuckets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.db")
+	at := time.Date(2025, 7, 15, 12, 0, 0, 0, time.UTC)
+
+	statsStorage, err := OpenStatsStorage(path)
+	assert.NoError(t, err)
+	assert.NoError(t, statsStorage.RecordEvent(MetricExpired, at))
+	// The minute bucket is merged into an hour, the hour into a day and
+	// the day dropped, all in one pass.
+	assert.NoError(t, statsStorage.CompactRollups(at.Add(10*365*24*time.Hour)))
+	assert.NoError(t, statsStorage.Close())
+
+	reopened, err := OpenStatsStorage(path)
+	assert.NoError(t, err)
+	defer reopened.Close()
+	assert.Empty(t, reopened.QueryRollups(Minute, time.Time{}, time.Time{}))
+}
@@ -2,18 +2,42 @@ package main
 
 import (
 	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"secretlinks/middleware"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/segmentio/kafka-go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
 type MockStatsStorage struct {
 	mock.Mock
 }
 
+func TestGetItemByID(t *testing.T) {
+	statsStorage := NewStatsStorage()
+	statsStorage.AddNewItem("newkey", time.Now())
+
+	item, exists := statsStorage.GetItemByID(middleware.RedactKey("newkey"))
+	assert.True(t, exists)
+	assert.Equal(t, "newkey", item.LinkKey)
+	_, exists = statsStorage.GetItemByID("newkey")
+	assert.False(t, exists, "items are not found by their key")
+}
+
 func TestAddNewItem(t *testing.T) {
 	statsStorage := NewStatsStorage()
 	statsStorage.AddNewItem("newkey", time.Now())
@@ -79,3 +103,280 @@ func (m *MockStorage) AppendVisitTime(key string, t time.Time) {
 func (m *MockStorage) ShowItem(key string) {
 	m.Called(key)
 }
+
+func TestConsumeDeadLettersUndecodableMessage(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bad := kafka.Message{Topic: "newlinks", Offset: 7, Key: []byte("bad"), Value: []byte("not json")}
+	good := kafka.Message{Topic: "newlinks", Offset: 8, Value: []byte(`{"linkkey":"good","nowtime":"2025-07-15T12:22:12Z"}`)}
+
+	reader := new(MockKafkaReader)
+	reader.On("FetchMessage", mock.Anything).Return(bad, nil).Once()
+	reader.On("FetchMessage", mock.Anything).Return(good, nil).Once()
+	reader.On("FetchMessage", mock.Anything).Run(func(mock.Arguments) { cancel() }).
+		Return(kafka.Message{}, context.Canceled).Once()
+	reader.On("CommitMessages", mock.Anything, mock.Anything).Return(nil)
+
+	deadLetters := NewDeadLetterFile(filepath.Join(t.TempDir(), "dlq.jsonl"))
+	statsStorage := NewStatsStorage()
+	consume(ctx, reader, KafkaReaderConfig{
+		Topic:      "newlinks",
+		Storage:    statsStorage,
+		Retry:      RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+		DeadLetter: deadLetters,
+	})
+
+	reader.AssertNumberOfCalls(t, "CommitMessages", 2)
+	assert.Equal(t, 1, len(statsStorage.items))
+
+	letters, err := deadLetters.List()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(letters))
+	assert.Equal(t, int64(7), letters[0].Offset)
+	assert.Equal(t, 1, letters[0].Attempts, "decode errors are not retried")
+	assert.Contains(t, letters[0].Reason, "decode")
+}
+
+func TestConsumeRetriesDeadLetterWrites(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bad := kafka.Message{Topic: "newlinks", Offset: 7, Value: []byte("not json")}
+	reader := new(MockKafkaReader)
+	reader.On("FetchMessage", mock.Anything).Return(bad, nil).Once()
+	reader.On("FetchMessage", mock.Anything).Run(func(mock.Arguments) { cancel() }).
+		Return(kafka.Message{}, context.Canceled).Once()
+	reader.On("CommitMessages", mock.Anything, mock.Anything).Return(nil)
+
+	// The dead-letter file cannot be written until its directory appears.
+	dir := filepath.Join(t.TempDir(), "later")
+	deadLetters := NewDeadLetterFile(filepath.Join(dir, "dlq.jsonl"))
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		os.Mkdir(dir, 0o700)
+	}()
+	consume(ctx, reader, KafkaReaderConfig{
+		Topic:      "newlinks",
+		Storage:    NewStatsStorage(),
+		Retry:      RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+		DeadLetter: deadLetters,
+	})
+
+	reader.AssertNumberOfCalls(t, "FetchMessage", 2)
+	reader.AssertNumberOfCalls(t, "CommitMessages", 1)
+	letters, err := deadLetters.List()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(letters))
+}
+
+func TestRedactValue(t *testing.T) {
+	dl := DeadLetter{Key: []byte("AbCdEfGh"), Value: []byte(`{"linkkey":"AbCdEfGh"}`)}
+	value := string(redactValue(dl))
+
+	assert.NotContains(t, value, "AbCdEfGh")
+	assert.Contains(t, value, middleware.RedactKey("AbCdEfGh"))
+	assert.Equal(t, "raw", string(redactValue(DeadLetter{Value: []byte("raw")})))
+}
+
+func TestConsumeRetriesFetchErrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reader := new(MockKafkaReader)
+	reader.On("FetchMessage", mock.Anything).Return(kafka.Message{}, errors.New("broker down")).Twice()
+	reader.On("FetchMessage", mock.Anything).Run(func(mock.Arguments) { cancel() }).
+		Return(kafka.Message{}, context.Canceled).Once()
+
+	consume(ctx, reader, KafkaReaderConfig{
+		Topic:   "newlinks",
+		Storage: NewStatsStorage(),
+		Retry:   RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+	})
+
+	reader.AssertNumberOfCalls(t, "FetchMessage", 3)
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 5, InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+
+	assert.Equal(t, 100*time.Millisecond, policy.Backoff(1))
+	assert.Equal(t, 200*time.Millisecond, policy.Backoff(2))
+	assert.Equal(t, 800*time.Millisecond, policy.Backoff(4))
+	assert.Equal(t, time.Second, policy.Backoff(10))
+}
+
+type MockMessageWriter struct {
+	mock.Mock
+}
+
+func (m *MockMessageWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	args := m.Called(ctx, msgs)
+	return args.Error(0)
+}
+
+func TestDeadLetterReplay(t *testing.T) {
+	deadLetters := NewDeadLetterFile(filepath.Join(t.TempDir(), "dlq.jsonl"))
+	reason := errors.New("boom")
+	assert.NoError(t, deadLetters.Append(NewDeadLetter(kafka.Message{Topic: "newlinks", Key: []byte("a")}, reason, 1)))
+	assert.NoError(t, deadLetters.Append(NewDeadLetter(kafka.Message{Topic: "updatelinks", Key: []byte("b")}, reason, 1)))
+
+	writer := new(MockMessageWriter)
+	writer.On("WriteMessages", mock.Anything, mock.Anything).Return(nil).Once()
+
+	replayed, err := deadLetters.Replay(context.Background(), writer, func(i int, _ DeadLetter) bool { return i == 1 })
+	assert.NoError(t, err)
+	assert.Equal(t, 1, replayed)
+
+	msgs := writer.Calls[0].Arguments[1].([]kafka.Message)
+	assert.Equal(t, "updatelinks", msgs[0].Topic)
+
+	letters, err := deadLetters.List()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(letters))
+	assert.Equal(t, "newlinks", letters[0].Topic)
+}
+
+func TestStatsStorageSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.db")
+	config := KafkaReaderConfig{Topic: "newlinks"}
+
+	statsStorage, err := OpenStatsStorage(path)
+	assert.NoError(t, err)
+	config.Storage = statsStorage
+	msg := kafka.Message{Partition: 0, Offset: 41, Value: []byte(`{"linkkey":"persisted","nowtime":"2025-07-15T12:22:12Z"}`)}
+	_, err = handleMessage(config, msg)
+	assert.NoError(t, err)
+	assert.NoError(t, statsStorage.AppendVisitTime("persisted", time.Now()))
+	assert.NoError(t, statsStorage.Close())
+
+	reopened, err := OpenStatsStorage(path)
+	assert.NoError(t, err)
+	defer reopened.Close()
+
+	assert.Equal(t, 1, len(reopened.items))
+	assert.Equal(t, 1, len(reopened.items["persisted"].VisitTime))
+	item, exists := reopened.GetItemByID(middleware.RedactKey("persisted"))
+	assert.True(t, exists, "the id index is rebuilt on open")
+	assert.Equal(t, "persisted", item.LinkKey)
+	assert.True(t, reopened.HasCheckpoints("newlinks"))
+	assert.True(t, reopened.Processed(Checkpoint{Topic: "newlinks", Partition: 0, Offset: 41}))
+	assert.False(t, reopened.Processed(Checkpoint{Topic: "newlinks", Partition: 0, Offset: 42}))
+	assert.False(t, reopened.HasCheckpoints("updatelinks"))
+}
+
+func TestHandleMessageSkipsProcessedOffsets(t *testing.T) {
+	statsStorage, err := OpenStatsStorage(filepath.Join(t.TempDir(), "stats.db"))
+	assert.NoError(t, err)
+	defer statsStorage.Close()
+	config := KafkaReaderConfig{Topic: "updatelinks", Storage: statsStorage}
+
+	msg := kafka.Message{Offset: 3, Value: []byte(`{"linkkey":"twice","nowtime":"2025-07-15T12:22:12Z"}`)}
+	_, err = handleMessage(config, msg)
+	assert.NoError(t, err)
+	assert.NoError(t, statsStorage.AppendVisitTime("twice", time.Now()))
+	_, err = handleMessage(config, msg)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 1, len(statsStorage.items["twice"].VisitTime))
+}
+
+func TestConsumeExportsMetrics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bad := kafka.Message{Topic: "updatelinks", Partition: 2, Offset: 10, HighWaterMark: 15, Value: []byte("not json")}
+	good := kafka.Message{Topic: "updatelinks", Partition: 2, Offset: 11, HighWaterMark: 15, Value: []byte(`{"linkkey":"m","nowtime":"2025-07-15T12:22:12Z"}`)}
+
+	reader := new(MockKafkaReader)
+	reader.On("FetchMessage", mock.Anything).Return(bad, nil).Once()
+	reader.On("FetchMessage", mock.Anything).Return(good, nil).Once()
+	reader.On("FetchMessage", mock.Anything).Run(func(mock.Arguments) { cancel() }).
+		Return(kafka.Message{}, context.Canceled).Once()
+	reader.On("CommitMessages", mock.Anything, mock.Anything).Return(nil)
+
+	processed := testutil.ToFloat64(messagesProcessed.WithLabelValues("updatelinks"))
+	deadLettered := testutil.ToFloat64(messagesDeadLettered.WithLabelValues("updatelinks"))
+	decodes := testutil.ToFloat64(decodeErrors.WithLabelValues("updatelinks", "application/json"))
+
+	consume(ctx, reader, KafkaReaderConfig{
+		Topic:      "updatelinks",
+		Storage:    NewStatsStorage(),
+		Retry:      RetryPolicy{MaxAttempts: 1},
+		DeadLetter: NewDeadLetterFile(filepath.Join(t.TempDir(), "dlq.jsonl")),
+	})
+
+	assert.Equal(t, processed+1, testutil.ToFloat64(messagesProcessed.WithLabelValues("updatelinks")))
+	assert.Equal(t, deadLettered+1, testutil.ToFloat64(messagesDeadLettered.WithLabelValues("updatelinks")))
+	assert.Equal(t, decodes+1, testutil.ToFloat64(decodeErrors.WithLabelValues("updatelinks", "application/json")))
+}
+
+type fakeReaderStats struct{ lag atomic.Int64 }
+
+func (f *fakeReaderStats) Stats() kafka.ReaderStats {
+	return kafka.ReaderStats{Lag: f.lag.Load()}
+}
+
+func TestWatchLagUpdatesWithoutMessages(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reader := new(fakeReaderStats)
+	reader.lag.Store(7)
+	go watchLag(ctx, "lagtopic", reader, time.Millisecond)
+
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(consumerLag.WithLabelValues("lagtopic")) == 7
+	}, time.Second, time.Millisecond)
+	reader.lag.Store(0)
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(consumerLag.WithLabelValues("lagtopic")) == 0
+	}, time.Second, time.Millisecond)
+}
+
+func TestStorageMetrics(t *testing.T) {
+	statsStorage := NewStatsStorage()
+	statsStorage.AddNewItem("a", time.Now())
+	statsStorage.AddNewItem("b", time.Now())
+
+	registry := prometheus.NewRegistry()
+	RegisterStorageMetrics(registry, statsStorage)
+
+	expected := `
+# HELP secretlinks_stats_storage_links Links held in the stats storage.
+# TYPE secretlinks_stats_storage_links gauge
+secretlinks_stats_storage_links 2
+`
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "secretlinks_stats_storage_links"))
+}
+
+func TestConsumeContinuesTrace(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	msg := kafka.Message{
+		Topic:   "newlinks",
+		Value:   []byte(`{"linkkey":"traced","nowtime":"2025-07-15T12:22:12Z"}`),
+		Headers: []kafka.Header{{Key: "traceparent", Value: []byte(traceparent)}},
+	}
+	reader := new(MockKafkaReader)
+	reader.On("FetchMessage", mock.Anything).Return(msg, nil).Once()
+	reader.On("FetchMessage", mock.Anything).Run(func(mock.Arguments) { cancel() }).
+		Return(kafka.Message{}, context.Canceled).Once()
+	reader.On("CommitMessages", mock.Anything, mock.Anything).Return(nil)
+
+	consume(ctx, reader, KafkaReaderConfig{Topic: "newlinks", Storage: NewStatsStorage(), Retry: RetryPolicy{MaxAttempts: 1}})
+
+	spans := recorder.Ended()
+	assert.Equal(t, 2, len(spans))
+	for _, span := range spans {
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext().TraceID().String())
+	}
+}
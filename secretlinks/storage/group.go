@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"slices"
+	"time"
+)
+
+// Group ties together the links created at once for several recipients of
+// the same secret, so the sender can follow who has opened theirs.
+type Group struct {
+	// TokenHash is the SHA-256 of the token that lets the sender read the
+	// group; the token itself is never stored.
+	TokenHash string
+	MaxViews  int
+	NotBefore time.Time
+	ExpiresAt time.Time
+	Members   []Member
+}
+
+// Member is one recipient's link within a group. It outlives the link, so
+// the group still tells an opened link from a revoked or unread one after
+// the link is gone.
+type Member struct {
+	Recipient string
+	Key       string
+	Views     int
+	// OpenedAt is the time of the first view; zero while unopened.
+	OpenedAt time.Time
+	Revoked  bool
+}
+
+// Member returns the member holding the link key and its index in
+// Members, or -1 when there is none.
+func (g Group) Member(key string) (Member, int) {
+	for i, member := range g.Members {
+		if member.Key == key {
+			return member, i
+		}
+	}
+	return Member{}, -1
+}
+
+// CreateGroup stores group under id unless the id is taken and reports
+// whether it did.
+func (s *MemoryStorage) CreateGroup(id string, group Group) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.groups[id]; exists {
+		return false
+	}
+	s.groups[id] = group
+	return true
+}
+
+func (s *MemoryStorage) GetGroup(id string) (Group, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	group, exists := s.groups[id]
+	// Callers update members in place before UpdateGroup.
+	group.Members = slices.Clone(group.Members)
+	return group, exists
+}
+
+func (s *MemoryStorage) UpdateGroup(id string, group Group) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.groups[id] = group
+}
+
+// UpdateMember applies update to the member holding the link key in the
+// group id, under the storage lock so views of different members are not
+// lost, and reports whether there was such a member.
+func (s *MemoryStorage) UpdateMember(id, key string, update func(*Member)) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	group, exists := s.groups[id]
+	if !exists {
+		return false
+	}
+	_, i := group.Member(key)
+	if i < 0 {
+		return false
+	}
+	update(&group.Members[i])
+	return true
+}
@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"net/netip"
 	"sync"
 	"time"
 )
@@ -10,6 +11,21 @@ type Link struct {
 	ExpiresAt time.Time
 	MaxViews  int
 	Views     int
+	// RevokeHash is the SHA-256 of the token that lets the creator revoke
+	// the link early; the token itself is never stored.
+	RevokeHash string
+	// AllowedNets restrict who may open the link; empty allows everybody.
+	AllowedNets []netip.Prefix `json:",omitempty"`
+	// NotBefore is when the link becomes available; zero means at once.
+	NotBefore time.Time
+	// WebhookURL is told about views, expiry and revocation, with requests
+	// signed by WebhookSecret.
+	WebhookURL    string `json:",omitempty"`
+	WebhookSecret string `json:",omitempty"`
+	// NotifyEmail is emailed when the link is viewed or expires unread.
+	NotifyEmail string `json:",omitempty"`
+	// Group is the id of the group the link was created in, if any.
+	Group string `json:",omitempty"`
 }
 
 type Storage interface {
@@ -20,13 +36,15 @@ type Storage interface {
 }
 
 type MemoryStorage struct {
-	mu    sync.Mutex
-	links map[string]Link
+	mu     sync.Mutex
+	links  map[string]Link
+	groups map[string]Group
 }
 
 func NewMemoryStorage() *MemoryStorage {
 	return &MemoryStorage{
-		links: make(map[string]Link),
+		links:  make(map[string]Link),
+		groups: make(map[string]Group),
 	}
 }
 
@@ -61,3 +79,29 @@ func (s *MemoryStorage) Delete(key string) {
 	defer s.mu.Unlock()
 	delete(s.links, key)
 }
+
+func (s *MemoryStorage) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.links)
+}
+
+// DeleteExpired removes every link and group whose expiration time is
+// before now and returns the links by key.
+func (s *MemoryStorage) DeleteExpired(now time.Time) map[string]Link {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expired := make(map[string]Link)
+	for key, link := range s.links {
+		if now.After(link.ExpiresAt) {
+			delete(s.links, key)
+			expired[key] = link
+		}
+	}
+	for id, group := range s.groups {
+		if now.After(group.ExpiresAt) {
+			delete(s.groups, id)
+		}
+	}
+	return expired
+}
@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// snapshotVersion marks the current format of SaveSnapshot. Older
+// snapshots hold just the map of links, whose keys may be any alias, so
+// they are told apart by the exact "version" key holding this number
+// rather than by which fields decode.
+const snapshotVersion = 1
+
+// snapshot is the file format of SaveSnapshot.
+type snapshot struct {
+	Version int              `json:"version"`
+	Links   map[string]Link  `json:"links"`
+	Groups  map[string]Group `json:"groups,omitempty"`
+}
+
+// SaveSnapshot writes all links and groups to path. The file is replaced atomically,
+// so a crash while saving leaves the previous snapshot intact. Secrets are
+// stored as they are kept in memory, under the server's built-in key, so
+// anyone who can read the file can read them; keep it as safe as the
+// secrets themselves.
+func (s *MemoryStorage) SaveSnapshot(path string) error {
+	s.mu.Lock()
+	data, err := json.Marshal(snapshot{Version: snapshotVersion, Links: s.links, Groups: s.groups})
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadSnapshot adds the links and groups saved by SaveSnapshot to the
+// storage, removes the file and returns how many links were loaded. The
+// file is removed so that after an exit without SaveSnapshot links used up
+// in the meantime do not come back. A missing file is not an error.
+func (s *MemoryStorage) LoadSnapshot(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var saved snapshot
+	if isVersioned(data) {
+		err = json.Unmarshal(data, &saved)
+	} else {
+		err = json.Unmarshal(data, &saved.Links)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if err := os.Remove(path); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, link := range saved.Links {
+		s.links[key] = link
+	}
+	for id, group := range saved.Groups {
+		s.groups[id] = group
+	}
+	return len(saved.Links), nil
+}
+
+// isVersioned reports whether data is a snapshot with a "version" key,
+// matched exactly: encoding/json matches field names case-insensitively,
+// so decoding into snapshot would also accept a link aliased "links".
+func isVersioned(data []byte) bool {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(data, &fields) != nil {
+		return false
+	}
+	var version int
+	return json.Unmarshal(fields["version"], &version) == nil && version == snapshotVersion
+}
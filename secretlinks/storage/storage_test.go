@@ -1,7 +1,11 @@
 package storage
 
 import (
+	"net/netip"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -64,3 +68,154 @@ func TestDelete(t *testing.T) {
 	assert.Equal(t, 0, len(memoryStorage.links))
 
 }
+
+func TestDeleteExpired(t *testing.T) {
+	now := time.Now()
+	memoryStorage := NewMemoryStorage()
+	old := Link{ExpiresAt: now.Add(-time.Minute), MaxViews: 2}
+	memoryStorage.Create("old", old, true)
+	memoryStorage.Create("fresh", Link{ExpiresAt: now.Add(time.Minute)}, true)
+	memoryStorage.CreateGroup("old", Group{ExpiresAt: now.Add(-time.Minute)})
+	memoryStorage.CreateGroup("fresh", Group{ExpiresAt: now.Add(time.Minute)})
+
+	expired := memoryStorage.DeleteExpired(now)
+
+	assert.Equal(t, map[string]Link{"old": old}, expired)
+	_, exist := memoryStorage.Get("old")
+	assert.False(t, exist)
+	assert.Equal(t, 1, memoryStorage.Len())
+	_, exist = memoryStorage.GetGroup("old")
+	assert.False(t, exist)
+	_, exist = memoryStorage.GetGroup("fresh")
+	assert.True(t, exist)
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "links.snapshot")
+	expiresAt := time.Now().Add(time.Hour).UTC()
+	memoryStorage := NewMemoryStorage()
+	memoryStorage.Create("key", Link{Secret: "encrypted", ExpiresAt: expiresAt, MaxViews: 3, Views: 1}, true)
+
+	assert.NoError(t, memoryStorage.SaveSnapshot(path))
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	restored := NewMemoryStorage()
+	n, err := restored.LoadSnapshot(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	link, exist := restored.Get("key")
+	assert.True(t, exist)
+	assert.Equal(t, "encrypted", link.Secret)
+	assert.Equal(t, 1, link.Views)
+	assert.True(t, expiresAt.Equal(link.ExpiresAt))
+}
+
+func TestSnapshotKeepsAllowedNets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "links.snapshot")
+	office := netip.MustParsePrefix("10.0.0.0/8")
+	memoryStorage := NewMemoryStorage()
+	memoryStorage.Create("key", Link{Secret: "encrypted", MaxViews: 1, AllowedNets: []netip.Prefix{office}}, true)
+	assert.NoError(t, memoryStorage.SaveSnapshot(path))
+
+	restored := NewMemoryStorage()
+	_, err := restored.LoadSnapshot(path)
+	assert.NoError(t, err)
+	link, exist := restored.Get("key")
+	assert.True(t, exist)
+	assert.Equal(t, []netip.Prefix{office}, link.AllowedNets)
+}
+
+func TestSnapshotKeepsGroups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "links.snapshot")
+	memoryStorage := NewMemoryStorage()
+	group := Group{TokenHash: "hash", MaxViews: 1, ExpiresAt: time.Now().Add(time.Hour).UTC(), Members: []Member{
+		{Recipient: "alice", Key: "key1", Views: 1, OpenedAt: time.Now().UTC()},
+		{Recipient: "bob", Key: "key2"},
+	}}
+	memoryStorage.CreateGroup("group", group)
+	assert.NoError(t, memoryStorage.SaveSnapshot(path))
+
+	restored := NewMemoryStorage()
+	_, err := restored.LoadSnapshot(path)
+	assert.NoError(t, err)
+	got, exist := restored.GetGroup("group")
+	assert.True(t, exist)
+	assert.Equal(t, "hash", got.TokenHash)
+	assert.Len(t, got.Members, 2)
+	member, i := got.Member("key2")
+	assert.Equal(t, 1, i)
+	assert.Equal(t, "bob", member.Recipient)
+}
+
+func TestLoadSnapshotOldFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "links.snapshot")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"key":{"Secret":"encrypted","MaxViews":1}}`), 0o600))
+
+	memoryStorage := NewMemoryStorage()
+	n, err := memoryStorage.LoadSnapshot(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	link, exist := memoryStorage.Get("key")
+	assert.True(t, exist)
+	assert.Equal(t, "encrypted", link.Secret)
+}
+
+func TestLoadSnapshotOldFormatWithFieldAliases(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "links.snapshot")
+	old := `{"links":{"Secret":"a","MaxViews":1},"Groups":{"Secret":"b","MaxViews":1},"version":{"Secret":"c","MaxViews":1}}`
+	assert.NoError(t, os.WriteFile(path, []byte(old), 0o600))
+
+	memoryStorage := NewMemoryStorage()
+	n, err := memoryStorage.LoadSnapshot(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	link, exist := memoryStorage.Get("links")
+	assert.True(t, exist)
+	assert.Equal(t, "a", link.Secret)
+}
+
+func TestCreateGroupNotUnique(t *testing.T) {
+	memoryStorage := NewMemoryStorage()
+
+	assert.True(t, memoryStorage.CreateGroup("group", Group{}))
+	assert.False(t, memoryStorage.CreateGroup("group", Group{}))
+}
+
+func TestUpdateMember(t *testing.T) {
+	memoryStorage := NewMemoryStorage()
+	memoryStorage.CreateGroup("group", Group{Members: []Member{{Recipient: "alice", Key: "key1"}}})
+
+	assert.True(t, memoryStorage.UpdateMember("group", "key1", func(m *Member) { m.Views++ }))
+	assert.False(t, memoryStorage.UpdateMember("group", "key2", func(m *Member) { m.Views++ }))
+	assert.False(t, memoryStorage.UpdateMember("other", "key1", func(m *Member) { m.Views++ }))
+
+	group, _ := memoryStorage.GetGroup("group")
+	assert.Equal(t, 1, group.Members[0].Views)
+}
+
+func TestLoadSnapshotRemovesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "links.snapshot")
+	memoryStorage := NewMemoryStorage()
+	memoryStorage.Create("key", Link{Secret: "encrypted", MaxViews: 1}, true)
+	assert.NoError(t, memoryStorage.SaveSnapshot(path))
+
+	n, err := NewMemoryStorage().LoadSnapshot(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	_, err = os.Stat(path)
+	assert.ErrorIs(t, err, os.ErrNotExist, "a second start must not bring the links back")
+
+	n, err = NewMemoryStorage().LoadSnapshot(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestLoadSnapshotMissingFile(t *testing.T) {
+	memoryStorage := NewMemoryStorage()
+	n, err := memoryStorage.LoadSnapshot(filepath.Join(t.TempDir(), "missing"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
@@ -0,0 +1,41 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestKafkaPropagation(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	provider := sdktrace.NewTracerProvider()
+	defer provider.Shutdown(context.Background())
+
+	ctx, span := provider.Tracer("test").Start(context.Background(), "parent")
+	defer span.End()
+
+	msg := kafka.Message{Headers: []kafka.Header{{Key: "content-type", Value: []byte("application/json")}}}
+	InjectKafka(ctx, &msg)
+
+	assert.Equal(t, 2, len(msg.Headers))
+	assert.NotEmpty(t, KafkaCarrier{Message: &msg}.Get("traceparent"))
+
+	extracted := trace.SpanContextFromContext(ExtractKafka(context.Background(), &msg))
+	assert.Equal(t, span.SpanContext().TraceID(), extracted.TraceID())
+	assert.True(t, extracted.IsRemote())
+}
+
+func TestSetupRejectsUnknownExporter(t *testing.T) {
+	_, err := Setup(context.Background(), "test", "zipkin")
+	assert.Error(t, err)
+
+	shutdown, err := Setup(context.Background(), "test", "none")
+	assert.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+}
@@ -0,0 +1,20 @@
+// Copy buttons are hidden in the markup and only shown when scripts run;
+// without JavaScript the field can still be selected and copied by hand.
+document.querySelectorAll("button[data-copy]").forEach(function (button) {
+  var field = document.getElementById(button.dataset.copy);
+  if (!field) {
+    return;
+  }
+  button.hidden = false;
+  button.addEventListener("click", function () {
+    field.select();
+    var done = function () {
+      button.textContent = "Copied";
+    };
+    if (navigator.clipboard) {
+      navigator.clipboard.writeText(field.value).then(done);
+    } else if (document.execCommand("copy")) {
+      done();
+    }
+  });
+});
@@ -0,0 +1,52 @@
+// Package web holds the HTML interface: page templates and the static
+// files they use, embedded in the binary so nothing is loaded from a CDN.
+package web
+
+import (
+	"embed"
+	"html/template"
+	"io"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+//go:embed static
+var staticFS embed.FS
+
+// ContentSecurityPolicy lets pages use the embedded stylesheet and script
+// and post forms back to the service, and nothing else.
+const ContentSecurityPolicy = "default-src 'none'; style-src 'self'; script-src 'self'; img-src 'self'; form-action 'self'; frame-ancestors 'none'; base-uri 'none'"
+
+var pages = map[string]*template.Template{}
+
+func init() {
+	names, err := fs.Glob(templateFS, "templates/*.html")
+	if err != nil {
+		panic(err)
+	}
+	for _, name := range names {
+		if name == "templates/layout.html" {
+			continue
+		}
+		page := name[len("templates/"):]
+		pages[page] = template.Must(template.ParseFS(templateFS, "templates/layout.html", name))
+	}
+}
+
+// Render writes the page named like its template file, e.g. "create.html".
+func Render(w io.Writer, page string, data any) error {
+	return pages[page].ExecuteTemplate(w, "layout", data)
+}
+
+// Static serves the embedded files under static/, with the path already
+// stripped of everything before it.
+func Static() http.Handler {
+	sub, err := fs.Sub(staticFS, "static")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServerFS(sub)
+}
@@ -0,0 +1,306 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"secretlinks/metrics"
+	"secretlinks/middleware"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy describes how many times a delivery is attempted and how
+// long to wait between attempts. The delay doubles after every failure.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: time.Second,
+	MaxBackoff:     time.Minute,
+}
+
+// Backoff returns the delay after the given failed attempt (starting at 1).
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	delay := p.InitialBackoff
+	for i := 1; i < attempt && delay < p.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	return delay
+}
+
+// Options configure a Dispatcher. Zero fields take the defaults.
+type Options struct {
+	Client    *http.Client // default NewHTTPClient(10*time.Second, false)
+	Retry     RetryPolicy  // default DefaultRetryPolicy
+	Log       *DeliveryLog // default the last 1000 attempts in memory
+	QueueSize int          // default 1000
+	Workers   int          // default 4
+}
+
+// Dispatcher delivers webhooks in the background, so a slow receiver does
+// not hold up HTTP requests.
+type Dispatcher struct {
+	client *http.Client
+	retry  RetryPolicy
+	log    *DeliveryLog
+	queue  chan delivery
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	workers sync.WaitGroup
+	mu      sync.RWMutex
+	closed  bool
+}
+
+type delivery struct {
+	id      string
+	hook    Hook
+	payload Payload
+}
+
+func NewDispatcher(opts Options) *Dispatcher {
+	if opts.Client == nil {
+		opts.Client = NewHTTPClient(10*time.Second, false)
+	}
+	if opts.Retry.MaxAttempts <= 0 {
+		opts.Retry = DefaultRetryPolicy
+	}
+	if opts.Log == nil {
+		opts.Log = NewDeliveryLog(nil, 1000)
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 1000
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = 4
+	}
+	d := &Dispatcher{
+		client: opts.Client,
+		retry:  opts.Retry,
+		log:    opts.Log,
+		queue:  make(chan delivery, opts.QueueSize),
+	}
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	for range opts.Workers {
+		d.workers.Add(1)
+		go d.run()
+	}
+	return d
+}
+
+// Send queues a delivery of payload to hook. It never blocks: when the
+// queue is full the delivery is dropped and false is returned.
+func (d *Dispatcher) Send(hook Hook, payload Payload) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		metrics.WebhookDeliveries.WithLabelValues("dropped").Inc()
+		return false
+	}
+	select {
+	case d.queue <- delivery{id: newDeliveryID(), hook: hook, payload: payload}:
+		return true
+	default:
+		slog.Warn("webhook queue full, dropping delivery", "event", payload.Event)
+		metrics.WebhookDeliveries.WithLabelValues("dropped").Inc()
+		return false
+	}
+}
+
+// Log returns the delivery log.
+func (d *Dispatcher) Log() *DeliveryLog {
+	return d.log
+}
+
+// Close stops accepting deliveries and finishes the queued ones, retries
+// included. When ctx is done first, pending retries are given up.
+func (d *Dispatcher) Close(ctx context.Context) error {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.queue)
+	}
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		d.cancel()
+		return nil
+	case <-ctx.Done():
+		d.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (d *Dispatcher) run() {
+	defer d.workers.Done()
+	for del := range d.queue {
+		d.deliver(del)
+	}
+}
+
+func (d *Dispatcher) deliver(del delivery) {
+	body, err := json.Marshal(del.payload)
+	if err != nil {
+		slog.Error("webhook encode error", "err", err)
+		return
+	}
+	for attempt := 1; ; attempt++ {
+		entry := Attempt{
+			Delivery: del.id,
+			KeyID:    middleware.RedactKey(del.payload.Key),
+			Event:    del.payload.Event,
+			URL:      del.hook.URL,
+			Attempt:  attempt,
+			At:       time.Now(),
+		}
+		status, err := d.post(del, body)
+		entry.Duration = time.Since(entry.At)
+		entry.StatusCode = status
+
+		retry := false
+		switch {
+		case err != nil:
+			entry.Error = err.Error()
+			retry = true
+		case status >= 200 && status < 300:
+			entry.Result = ResultDelivered
+		case status == http.StatusTooManyRequests, status >= 500:
+			retry = true
+		}
+		// Other answers, such as 404 or a redirect, will not change.
+		if entry.Result == "" {
+			entry.Result = ResultFailed
+			if retry && attempt < d.retry.MaxAttempts && d.ctx.Err() == nil {
+				entry.Result = ResultRetry
+			}
+		}
+		metrics.WebhookDeliveries.WithLabelValues(entry.Result).Inc()
+		if err := d.log.Record(entry); err != nil {
+			slog.Error("webhook log error", "err", err)
+		}
+		if entry.Result != ResultRetry || !sleepContext(d.ctx, d.retry.Backoff(attempt)) {
+			return
+		}
+	}
+}
+
+func (d *Dispatcher) post(del delivery, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, del.hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "secretlinks-webhooks")
+	req.Header.Set(EventHeader, del.payload.Event)
+	req.Header.Set(DeliveryHeader, del.id)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(del.hook.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func newDeliveryID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// ValidateURL checks a callback URL given by a link creator.
+func ValidateURL(raw string) error {
+	if len(raw) > 2048 {
+		return errors.New("webhook URL is too long")
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("webhook URL must be an absolute http or https URL")
+	}
+	if u.User != nil {
+		return errors.New("webhook URL must not contain credentials")
+	}
+	return nil
+}
+
+// NewHTTPClient returns the client deliveries are made with. Redirects are
+// not followed and no proxy is used. Unless allowPrivate is set, it refuses
+// to connect to loopback, private, link-local and similar addresses, so
+// a callback URL cannot reach services inside the network; the check is
+// made on the address actually dialled, after DNS resolution.
+func NewHTTPClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			addr, err := netip.ParseAddr(host)
+			if err != nil || !isPublic(addr) {
+				return fmt.Errorf("webhooks: refusing to connect to non-public address %s", host)
+			}
+			return nil
+		}
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+			MaxIdleConnsPerHost: 2,
+			IdleConnTimeout:     time.Minute,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// sharedAddressSpace is carrier-grade NAT, RFC 6598.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+func isPublic(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddressSpace.Contains(addr)
+}
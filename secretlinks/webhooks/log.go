@@ -0,0 +1,97 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Attempt is one entry of the delivery log. KeyID is the link key's id as
+// in the server logs; the key itself opens the link and is never logged.
+type Attempt struct {
+	Delivery   string        `json:"delivery"`
+	KeyID      string        `json:"key_id"`
+	Event      string        `json:"event"`
+	URL        string        `json:"url"`
+	Attempt    int           `json:"attempt"`
+	At         time.Time     `json:"at"`
+	Duration   time.Duration `json:"duration"`
+	StatusCode int           `json:"status_code,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	// Result is "delivered", "retry" or "failed" when no attempt is left.
+	Result string `json:"result"`
+}
+
+// Results of an attempt.
+const (
+	ResultDelivered = "delivered"
+	ResultRetry     = "retry"
+	ResultFailed    = "failed"
+)
+
+// DeliveryLog appends every attempt as a JSON line to a writer and keeps
+// the most recent ones in memory.
+type DeliveryLog struct {
+	mu     sync.Mutex
+	w      io.Writer
+	recent []Attempt
+	next   int
+	full   bool
+}
+
+// NewDeliveryLog keeps the last keep attempts; w may be nil.
+func NewDeliveryLog(w io.Writer, keep int) *DeliveryLog {
+	return &DeliveryLog{w: w, recent: make([]Attempt, max(keep, 1))}
+}
+
+// OpenDeliveryLog appends to the file at path, creating it readable by the
+// owner only: the log holds callback URLs. The file is never rotated
+// here; since it is only appended to, it can be truncated in place, e.g.
+// by logrotate with copytruncate.
+func OpenDeliveryLog(path string, keep int) (*DeliveryLog, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return NewDeliveryLog(f, keep), nil
+}
+
+// Record adds an attempt.
+func (l *DeliveryLog) Record(a Attempt) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.recent[l.next] = a
+	l.next = (l.next + 1) % len(l.recent)
+	l.full = l.full || l.next == 0
+	if l.w == nil {
+		return nil
+	}
+	line, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	_, err = l.w.Write(append(line, '\n'))
+	return err
+}
+
+// Recent returns the attempts kept in memory, oldest first.
+func (l *DeliveryLog) Recent() []Attempt {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.full {
+		return append([]Attempt(nil), l.recent[:l.next]...)
+	}
+	return append(append([]Attempt(nil), l.recent[l.next:]...), l.recent[:l.next]...)
+}
+
+// Close closes the underlying writer if it is a closer.
+func (l *DeliveryLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if c, ok := l.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
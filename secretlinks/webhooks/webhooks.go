@@ -0,0 +1,80 @@
+// Package webhooks tells link creators what happens to their links by
+// POSTing signed JSON to a callback URL they registered. Deliveries run in
+// the background, are retried with backoff and are written to a log.
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Events a webhook is sent for.
+const (
+	EventViewed    = "viewed"
+	EventExhausted = "exhausted" // the last view was used up
+	EventExpired   = "expired"   // the time ran out
+	EventRevoked   = "revoked"
+)
+
+// Headers of every delivery.
+const (
+	EventHeader     = "X-Secretlinks-Event"
+	DeliveryHeader  = "X-Secretlinks-Delivery"
+	TimestampHeader = "X-Secretlinks-Timestamp"
+	SignatureHeader = "X-Secretlinks-Signature"
+)
+
+// Hook is where the events of one link go. Secret signs the deliveries.
+type Hook struct {
+	URL    string
+	Secret string
+}
+
+// Payload is the JSON body of a delivery. It never contains the secret.
+type Payload struct {
+	Event      string    `json:"event"`
+	Key        string    `json:"key"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Views      int       `json:"views"`
+	MaxViews   int       `json:"max_views"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// Sign returns the signature header value of body sent at timestamp (Unix
+// seconds): "sha256=" and the hex HMAC-SHA256 of "timestamp.body".
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Errors returned by Verify.
+var (
+	ErrBadSignature = errors.New("webhooks: signature does not match")
+	ErrStale        = errors.New("webhooks: timestamp outside tolerance")
+)
+
+// Verify checks a received delivery, for receivers written in Go. Deliveries
+// whose timestamp is further than tolerance from now are refused, so a
+// captured request cannot be replayed later.
+func Verify(secret string, header http.Header, body []byte, tolerance time.Duration) error {
+	timestamp := header.Get(TimestampHeader)
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrBadSignature
+	}
+	if age := time.Since(time.Unix(seconds, 0)); age > tolerance || age < -tolerance {
+		return ErrStale
+	}
+	if !hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(header.Get(SignatureHeader))) {
+		return ErrBadSignature
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"secretlinks/middleware"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var fastRetry = RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+
+// newDispatcher may reach httptest servers on the loopback address.
+func newDispatcher(t *testing.T, retry RetryPolicy) *Dispatcher {
+	d := NewDispatcher(Options{Client: NewHTTPClient(time.Second, true), Retry: retry})
+	t.Cleanup(func() { d.Close(context.Background()) })
+	return d
+}
+
+func results(log *DeliveryLog) []string {
+	var out []string
+	for _, a := range log.Recent() {
+		out = append(out, a.Result)
+	}
+	return out
+}
+
+func TestDeliversSignedPayload(t *testing.T) {
+	var mu sync.Mutex
+	var got Payload
+	var verifyErr error
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		verifyErr = Verify("hook-secret", r.Header, body, time.Minute)
+		json.Unmarshal(body, &got)
+		assert.Equal(t, EventViewed, r.Header.Get(EventHeader))
+		assert.NotEmpty(t, r.Header.Get(DeliveryHeader))
+	}))
+	defer receiver.Close()
+
+	d := newDispatcher(t, fastRetry)
+	sent := Payload{Event: EventViewed, Key: "AbCdEfGh", OccurredAt: time.Now().UTC().Truncate(time.Second), Views: 1, MaxViews: 3}
+	assert.True(t, d.Send(Hook{URL: receiver.URL, Secret: "hook-secret"}, sent))
+	require.NoError(t, d.Close(context.Background()))
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.NoError(t, verifyErr)
+	assert.Equal(t, sent.Key, got.Key)
+	assert.True(t, sent.OccurredAt.Equal(got.OccurredAt))
+	assert.Equal(t, []string{ResultDelivered}, results(d.Log()))
+}
+
+func TestDeliveryLogHidesKeys(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer receiver.Close()
+	var file bytes.Buffer
+	d := NewDispatcher(Options{Client: NewHTTPClient(time.Second, true), Retry: fastRetry, Log: NewDeliveryLog(&file, 10)})
+
+	d.Send(Hook{URL: receiver.URL}, Payload{Event: EventViewed, Key: "AbCdEfGh"})
+	require.NoError(t, d.Close(context.Background()))
+
+	assert.NotContains(t, file.String(), "AbCdEfGh", "the key opens the link")
+	assert.Contains(t, file.String(), middleware.RedactKey("AbCdEfGh"))
+	assert.Equal(t, middleware.RedactKey("AbCdEfGh"), d.Log().Recent()[0].KeyID)
+}
+
+func TestRetriesFailedDeliveries(t *testing.T) {
+	var calls atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer receiver.Close()
+
+	d := newDispatcher(t, fastRetry)
+	d.Send(Hook{URL: receiver.URL, Secret: "s"}, Payload{Event: EventRevoked, Key: "k"})
+	require.NoError(t, d.Close(context.Background()))
+
+	assert.Equal(t, int32(3), calls.Load())
+	assert.Equal(t, []string{ResultRetry, ResultRetry, ResultDelivered}, results(d.Log()))
+	attempts := d.Log().Recent()
+	assert.Equal(t, attempts[0].Delivery, attempts[2].Delivery, "retries keep the delivery id")
+	assert.Equal(t, http.StatusServiceUnavailable, attempts[0].StatusCode)
+	assert.Equal(t, 3, attempts[2].Attempt)
+}
+
+func TestGivesUp(t *testing.T) {
+	var calls atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+	gone := httptest.NewServer(http.NotFoundHandler())
+	defer gone.Close()
+
+	d := newDispatcher(t, fastRetry)
+	d.Send(Hook{URL: receiver.URL}, Payload{Event: EventExpired, Key: "a"})
+	d.Send(Hook{URL: gone.URL}, Payload{Event: EventExpired, Key: "b"})
+	require.NoError(t, d.Close(context.Background()))
+
+	assert.Equal(t, int32(fastRetry.MaxAttempts), calls.Load())
+	var last = map[string]Attempt{}
+	for _, a := range d.Log().Recent() {
+		last[a.KeyID] = a
+	}
+	a, b := middleware.RedactKey("a"), middleware.RedactKey("b")
+	assert.Equal(t, ResultFailed, last[a].Result)
+	assert.Equal(t, fastRetry.MaxAttempts, last[a].Attempt)
+	assert.Equal(t, ResultFailed, last[b].Result)
+	assert.Equal(t, 1, last[b].Attempt, "a 404 is not retried")
+}
+
+func TestRefusesPrivateAddresses(t *testing.T) {
+	var calls atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	}))
+	defer receiver.Close()
+
+	d := NewDispatcher(Options{Retry: RetryPolicy{MaxAttempts: 1}})
+	d.Send(Hook{URL: receiver.URL}, Payload{Event: EventViewed, Key: "k"})
+	require.NoError(t, d.Close(context.Background()))
+
+	assert.Zero(t, calls.Load())
+	attempts := d.Log().Recent()
+	require.Len(t, attempts, 1)
+	assert.Equal(t, ResultFailed, attempts[0].Result)
+	assert.Contains(t, attempts[0].Error, "non-public address")
+}
+
+func TestCloseGivesUpPendingRetries(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer receiver.Close()
+
+	d := newDispatcher(t, RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Hour, MaxBackoff: time.Hour})
+	d.Send(Hook{URL: receiver.URL}, Payload{Event: EventViewed, Key: "k"})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, d.Close(ctx), context.DeadlineExceeded)
+	assert.False(t, d.Send(Hook{URL: receiver.URL}, Payload{}), "closed dispatchers drop deliveries")
+}
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"event":"viewed"}`)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	header := http.Header{}
+	header.Set(TimestampHeader, now)
+	header.Set(SignatureHeader, Sign("secret", now, body))
+	assert.NoError(t, Verify("secret", header, body, time.Minute))
+	assert.ErrorIs(t, Verify("other", header, body, time.Minute), ErrBadSignature)
+	assert.ErrorIs(t, Verify("secret", header, []byte(`{"event":"revoked"}`), time.Minute), ErrBadSignature)
+
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	header.Set(TimestampHeader, old)
+	header.Set(SignatureHeader, Sign("secret", old, body))
+	assert.ErrorIs(t, Verify("secret", header, body, time.Minute), ErrStale)
+}
+
+func TestDeliveryLog(t *testing.T) {
+	var buf bytes.Buffer
+	log := NewDeliveryLog(&buf, 2)
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, log.Record(Attempt{KeyID: strconv.Itoa(i), Result: ResultDelivered}))
+	}
+	recent := log.Recent()
+	require.Len(t, recent, 2)
+	assert.Equal(t, "2", recent[0].KeyID)
+	assert.Equal(t, "3", recent[1].KeyID)
+	assert.Equal(t, 3, bytes.Count(buf.Bytes(), []byte("\n")), "the writer gets every attempt")
+}
+
+func TestValidateURL(t *testing.T) {
+	assert.NoError(t, ValidateURL("https://hooks.example.com/secretlinks?team=ops"))
+	for _, raw := range []string{"", "hooks.example.com/x", "ftp://example.com", "https://user:pw@example.com/", "/relative"} {
+		assert.Error(t, ValidateURL(raw), raw)
+	}
+}